@@ -8,6 +8,9 @@ var (
 	UserAgent   = ProgName + "/" + ProgVersion
 )
 
+// SchemaVersion is the version of the JSON report and JSONL events, raised on incompatible changes
+const SchemaVersion = 1
+
 // GetIPResults represents the returned JSON from backend server's getIP.php endpoint
 type GetIPResult struct {
 	ProcessedString string         `json:"processedString"`
@@ -29,8 +32,22 @@ type IPInfoResponse struct {
 }
 
 type TransferSummaryResponse struct {
-	Bitrate      float64 `json:"bitrate"`
-	TotalBytes   int     `json:"total_bytes"`
-	TotalPackets int     `json:"total_packets"`
-	Elapsed      int64   `json:"elapsed"`
+	Bitrate      float64            `json:"bitrate"`
+	TotalBytes   int                `json:"total_bytes"`
+	TotalPackets int                `json:"total_packets"`
+	Elapsed      int64              `json:"elapsed"`
+	Interface    *InterfaceCounters `json:"interface,omitempty"`
+	// EndReason tells whether the transfer ran for the whole duration, or stopped at the byte cap
+	EndReason string `json:"end_reason,omitempty"`
+}
+
+// InterfaceCounters represents the change of the test interface's counters during a transfer, and the bytes on the
+// wire compared to the bytes transferred by the test. Other traffic on the interface is counted too
+type InterfaceCounters struct {
+	Name            string         `json:"name"`
+	Delta           InterfaceStats `json:"delta"`
+	WireBytes       int64          `json:"wire_bytes"`
+	OverheadBytes   int64          `json:"overhead_bytes"`
+	OverheadPercent float64        `json:"overhead_percent"`
+	Warning         string         `json:"warning,omitempty"`
 }
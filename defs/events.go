@@ -0,0 +1,141 @@
+package defs
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// JSONEvent holds the fields common to all JSONL events. Events are numbered by Seq from 1 within the run identified by
+// RunID
+type JSONEvent struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Type          string    `json:"type"`
+	Timestamp     time.Time `json:"timestamp"`
+	RunID         string    `json:"runId"`
+	Seq           int64     `json:"seq"`
+}
+
+// Envelope returns the common fields of the event
+func (e *JSONEvent) Envelope() *JSONEvent {
+	return e
+}
+
+// Event is a JSONL event, embedding JSONEvent
+type Event interface {
+	Envelope() *JSONEvent
+}
+
+// JSONError is sent for every error logged during the run
+type JSONError struct {
+	JSONEvent
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Listener receives the events as they're sent, e.g. to show them live. It's called with the stream locked, so it
+// must neither send events nor log errors
+type Listener func(eventType string, e Event)
+
+// eventStream is where the JSONL events are written in JSONL mode, and the listener they're passed to
+var eventStream struct {
+	sync.Mutex
+	w        io.Writer
+	listener Listener
+	runID    string
+	seq      int64
+}
+
+// StartEvents writes the JSONL events to w from now on, numbered within a new run
+func StartEvents(w io.Writer) {
+	eventStream.Lock()
+	defer eventStream.Unlock()
+
+	eventStream.w = w
+	eventStream.runID = newRunID()
+	eventStream.seq = 0
+}
+
+// StopEvents stops writing the JSONL events
+func StopEvents() {
+	eventStream.Lock()
+	defer eventStream.Unlock()
+
+	eventStream.w = nil
+}
+
+// SetListener passes the events to l from now on, or to no listener if nil
+func SetListener(l Listener) {
+	eventStream.Lock()
+	defer eventStream.Unlock()
+
+	eventStream.listener = l
+}
+
+// SendEvent numbers the event and writes it as a line of the JSONL stream if started, and passes it to the listener
+func SendEvent(eventType string, e Event) {
+	eventStream.Lock()
+	defer eventStream.Unlock()
+
+	if eventStream.w == nil && eventStream.listener == nil {
+		return
+	}
+
+	eventStream.seq++
+	env := e.Envelope()
+	env.SchemaVersion = SchemaVersion
+	env.Type = eventType
+	env.Timestamp = time.Now()
+	env.RunID = eventStream.runID
+	env.Seq = eventStream.seq
+
+	if eventStream.listener != nil {
+		eventStream.listener(eventType, e)
+	}
+	if eventStream.w == nil {
+		return
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		// not logged as an error, which would be sent as an event again
+		log.Debugf("Error generating %s event: %s", eventType, err)
+		return
+	}
+	if _, err := fmt.Fprintf(eventStream.w, "%s\n", b); err != nil {
+		log.Debugf("Error writing %s event: %s", eventType, err)
+	}
+}
+
+// newRunID returns a random UUID identifying a run
+func newRunID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ErrorEventHook sends the errors logged as error events of the JSONL stream
+type ErrorEventHook struct{}
+
+// Levels implements logrus.Hook
+func (ErrorEventHook) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel}
+}
+
+// Fire implements logrus.Hook
+func (ErrorEventHook) Fire(entry *log.Entry) error {
+	var event JSONError
+	event.Error.Message = Redact(entry.Message)
+	SendEvent("error", &event)
+	return nil
+}
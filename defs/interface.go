@@ -0,0 +1,206 @@
+package defs
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// sysClassNet is where Linux exposes the network interfaces
+	sysClassNet = "/sys/class/net"
+
+	// ARPHRD_* hardware types of tunnel interfaces, from linux/if_arp.h
+	arphrdPPP     = 512
+	arphrdTunnel  = 768
+	arphrdTunnel6 = 769
+	arphrdSit     = 776
+	arphrdIPGRE   = 778
+	arphrdIP6GRE  = 823
+	arphrdNone    = 65534
+)
+
+// vpnInterfacePrefixes are the name prefixes of VPN interfaces, used when the interface type can't be read
+var vpnInterfacePrefixes = []string{"tun", "tap", "wg", "ppp", "utun", "ipsec", "zt", "tailscale"}
+
+// WanInterface returns the interface the tests go through: the one given in --interface, or else the interface of the
+// local address connected from, or of the route to the server. It falls back to the default route's interface
+func (s *Server) WanInterface() net.Interface {
+	if s.Interface != "" {
+		if iface, err := net.InterfaceByName(s.Interface); err == nil {
+			return *iface
+		}
+	}
+
+	if s.LocalIP != "" {
+		if iface, ok := interfaceByIP(net.ParseIP(s.LocalIP)); ok {
+			return iface
+		}
+	}
+
+	if s.IP != "" {
+		if iface, ok := routeInterface(s.IP); ok {
+			return iface
+		}
+	}
+
+	return GetWanInterface()
+}
+
+// routeInterface finds the interface of the route to the IP address, from the local address the kernel picks for a UDP
+// socket connected to it. No packets are sent
+func routeInterface(ip string) (net.Interface, bool) {
+	conn, err := net.Dial("udp", net.JoinHostPort(ip, "53"))
+	if err != nil {
+		return net.Interface{}, false
+	}
+	defer conn.Close()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return net.Interface{}, false
+	}
+	return interfaceByIP(addr.IP)
+}
+
+// interfaceByIP returns the interface having the IP address
+func interfaceByIP(ip net.IP) (net.Interface, bool) {
+	if ip == nil {
+		return net.Interface{}, false
+	}
+
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, false
+	}
+	for _, iface := range ifs {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface, true
+			}
+		}
+	}
+	return net.Interface{}, false
+}
+
+// IsVpnInterface tells whether the interface is a VPN or tunnel, like tun, WireGuard or PPP interfaces. On Linux this
+// is read from the interface type in sysfs, elsewhere it is guessed from the interface name
+func IsVpnInterface(iface *net.Interface) bool {
+	if iface.Name == "" {
+		return false
+	}
+
+	dir := filepath.Join(sysClassNet, iface.Name)
+	if b, err := os.ReadFile(filepath.Join(dir, "type")); err == nil {
+		// tun devices have a tun_flags attribute, tap devices are otherwise Ethernet-like
+		if _, err := os.Stat(filepath.Join(dir, "tun_flags")); err == nil {
+			return true
+		}
+
+		if b, err := os.ReadFile(filepath.Join(dir, "uevent")); err == nil {
+			for _, line := range strings.Split(string(b), "\n") {
+				if devType, ok := strings.CutPrefix(line, "DEVTYPE="); ok {
+					switch devType {
+					case "wireguard", "ppp", "tun", "tap", "ipsec", "vti", "vti6", "gre", "gretap", "ip6gre", "ip6tnl", "ipip", "sit":
+						return true
+					}
+				}
+			}
+		}
+
+		typ, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return false
+		}
+		switch typ {
+		case arphrdPPP, arphrdTunnel, arphrdTunnel6, arphrdSit, arphrdIPGRE, arphrdIP6GRE, arphrdNone:
+			return true
+		}
+		return false
+	}
+
+	for _, prefix := range vpnInterfacePrefixes {
+		if strings.HasPrefix(iface.Name, prefix) {
+			return true
+		}
+	}
+	return iface.Flags&net.FlagPointToPoint != 0
+}
+
+// interfaceAddr returns the first IPv4 or IPv6 address of the named interface, or an empty string if there is none
+func interfaceAddr(name string, ipv4 bool) string {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ""
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return ""
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() != nil) != ipv4 {
+			continue
+		}
+		// link local IPv6 addresses need a zone, which can't be given as the source
+		if !ipv4 && ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipNet.IP.String()
+	}
+	return ""
+}
+
+// newInterfaceCounters computes the counters of a transfer from the interface stats before and after it. The wire bytes
+// are the received bytes for downloads and the transmitted bytes for uploads
+func newInterfaceCounters(iface *net.Interface, before, after InterfaceStats, appBytes int, download bool) *InterfaceCounters {
+	delta := after.Sub(before)
+	counters := &InterfaceCounters{
+		Name:  iface.Name,
+		Delta: delta,
+	}
+
+	if download {
+		counters.WireBytes = delta.RxBytes
+	} else {
+		counters.WireBytes = delta.TxBytes
+	}
+	counters.OverheadBytes = counters.WireBytes - int64(appBytes)
+	if appBytes > 0 {
+		counters.OverheadPercent = math.Round(float64(counters.OverheadBytes)/float64(appBytes)*10000) / 100
+	}
+
+	// errors and drops hint at a faulty link or an overloaded host
+	var problems []string
+	for _, c := range []struct {
+		name  string
+		value int64
+	}{
+		{"receive errors", delta.RxErrors},
+		{"receive drops", delta.RxDropped},
+		{"receive FIFO errors", delta.RxFifo},
+		{"receive frame errors", delta.RxFrame},
+		{"transmit errors", delta.TxErrors},
+		{"transmit drops", delta.TxDropped},
+		{"transmit FIFO errors", delta.TxFifo},
+		{"transmit carrier errors", delta.TxCarrier},
+	} {
+		if c.value > 0 {
+			problems = append(problems, fmt.Sprintf("%d %s", c.value, c.name))
+		}
+	}
+	if len(problems) > 0 {
+		counters.Warning = fmt.Sprintf("%s on interface %s", strings.Join(problems, ", "), iface.Name)
+	}
+
+	return counters
+}
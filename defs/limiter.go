@@ -0,0 +1,130 @@
+package defs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// EndReasonDuration is the end reason of transfers that ran for the whole test duration
+	EndReasonDuration = "duration"
+	// EndReasonByteCap is the end reason of transfers stopped by --max-bytes or --max-total-bytes
+	EndReasonByteCap = "byte_cap"
+	// EndReasonSkippedByteCap is the end reason of transfers not started as --max-total-bytes was already reached
+	EndReasonSkippedByteCap = "skipped_byte_cap"
+)
+
+// TransferLimiter caps the bytes transferred by all the concurrent streams of a test, and throttles them to a rate
+type TransferLimiter struct {
+	// maxBytes is the byte cap, 0 for no cap
+	maxBytes int64
+	// rate is in bytes per second, 0 for no rate limit
+	rate float64
+
+	mu      sync.Mutex
+	used    int64
+	next    time.Time
+	reached chan struct{}
+}
+
+// NewTransferLimiter creates a limiter for one transfer, with 0 disabling the byte cap or the rate limit
+func NewTransferLimiter(maxBytes int64, rate float64) *TransferLimiter {
+	return &TransferLimiter{
+		maxBytes: maxBytes,
+		rate:     rate,
+		reached:  make(chan struct{}),
+	}
+}
+
+// Reached is closed once the byte cap is reached
+func (l *TransferLimiter) Reached() <-chan struct{} {
+	return l.reached
+}
+
+// take reserves up to n bytes, waiting as needed to keep the rate. It returns 0 once the byte cap is reached
+func (l *TransferLimiter) take(ctx context.Context, n int) (int, error) {
+	l.mu.Lock()
+	if l.maxBytes > 0 {
+		if remaining := l.maxBytes - l.used; remaining <= 0 {
+			l.mu.Unlock()
+			return 0, nil
+		} else if int64(n) > remaining {
+			n = int(remaining)
+		}
+	}
+	l.used += int64(n)
+
+	// each reservation is scheduled after the previous one, so the streams share the rate
+	var wait time.Duration
+	if l.rate > 0 {
+		now := time.Now()
+		if l.next.Before(now) {
+			l.next = now
+		}
+		wait = l.next.Sub(now)
+		l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			l.release(n)
+			return 0, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return n, nil
+}
+
+// release gives back reserved bytes that weren't transferred, and signals when the byte cap is reached
+func (l *TransferLimiter) release(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.used -= int64(n)
+	if l.maxBytes > 0 && l.used >= l.maxBytes {
+		select {
+		case <-l.reached:
+		default:
+			close(l.reached)
+		}
+	}
+}
+
+// Reader wraps the reader so that reads are throttled and stop at the byte cap
+func (l *TransferLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil || (l.maxBytes == 0 && l.rate == 0) {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiter: l}
+}
+
+// limitedReader is a reader throttled by a TransferLimiter, returning io.EOF once the byte cap is reached
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *TransferLimiter
+}
+
+// Read implements io.Reader
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return r.r.Read(p)
+	}
+
+	n, err := r.limiter.take(r.ctx, len(p))
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+
+	read, err := r.r.Read(p[:n])
+	r.limiter.release(n - read)
+	return read, err
+}
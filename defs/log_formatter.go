@@ -6,10 +6,23 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// ANSI escape sequences coloring the errors
+const (
+	colorRed   = "\x1b[31m"
+	colorReset = "\x1b[0m"
+)
+
 // NoFormatter is the formatter for logrus
-type NoFormatter struct{}
+type NoFormatter struct {
+	// Color shows the errors in red
+	Color bool
+}
 
-// Format prints the log message without timestamp/log level etc.
+// Format prints the log message without timestamp/log level etc., and with secrets redacted
 func (f *NoFormatter) Format(entry *log.Entry) ([]byte, error) {
-	return []byte(fmt.Sprintf("%s\n", entry.Message)), nil
+	msg := Redact(entry.Message)
+	if f.Color && entry.Level <= log.ErrorLevel && msg != "" {
+		msg = colorRed + msg + colorReset
+	}
+	return []byte(fmt.Sprintf("%s\n", msg)), nil
 }
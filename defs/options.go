@@ -6,6 +6,7 @@ const (
 	OptionIPv4Alt         = "4"
 	OptionIPv6            = "ipv6"
 	OptionIPv6Alt         = "6"
+	OptionDualStack       = "dual-stack"
 	OptionNoDownload      = "no-download"
 	OptionNoUpload        = "no-upload"
 	OptionNoICMP          = "no-icmp"
@@ -18,19 +19,55 @@ const (
 	OptionCSV             = "csv"
 	OptionCSVDelimiter    = "csv-delimiter"
 	OptionCSVHeader       = "csv-header"
+	OptionCSVWithHeader   = "csv-with-header"
+	OptionCSVFields       = "csv-fields"
 	OptionJSON            = "json"
 	OptionJSONL           = "jsonl"
+	OptionFormat          = "format"
+	OptionOutput          = "output"
+	OptionAppend          = "append"
+	OptionTUI             = "tui"
+	OptionQuiet           = "quiet"
+	OptionNoColor         = "no-color"
+	OptionSink            = "sink"
+	OptionSinkTimeout     = "sink-timeout"
+	OptionSinkRetries     = "sink-retries"
+	OptionSinkSpool       = "sink-spool"
 	OptionList            = "list"
+	OptionPing            = "ping"
+	OptionSort            = "sort"
+	OptionFilterName      = "filter-name"
+	OptionFilterCountry   = "filter-country"
+	OptionFilterSponsor   = "filter-sponsor"
+	OptionSelectWorkers   = "selection-workers"
+	OptionSelectTimeout   = "selection-timeout"
+	OptionServerTimeout   = "selection-server-timeout"
 	OptionServer          = "server"
 	OptionExclude         = "exclude"
 	OptionServerJSON      = "server-json"
 	OptionSource          = "source"
+	OptionInterface       = "interface"
+	OptionNetns           = "netns"
+	OptionProxy           = "proxy"
+	OptionHTTPVersion     = "http-version"
+	OptionDNSServer       = "dns-server"
+	OptionResolve         = "resolve"
 	OptionTimeout         = "timeout"
 	OptionChunks          = "chunks"
 	OptionUploadSize      = "upload-size"
+	OptionUploadPayload   = "upload-payload"
 	OptionDuration        = "duration"
+	OptionMaxBytes        = "max-bytes"
+	OptionMaxTotalBytes   = "max-total-bytes"
+	OptionRateLimit       = "rate-limit"
 	OptionSecure          = "secure"
 	OptionSkipCertVerify  = "skip-cert-verify"
+	OptionCACert          = "ca-cert"
+	OptionClientCert      = "client-cert"
+	OptionClientKey       = "client-key"
+	OptionHeader          = "header"
+	OptionAuthBearer      = "auth-bearer"
+	OptionAuthBasic       = "auth-basic"
 	OptionNoPreAllocate   = "no-pre-allocate"
 	OptionVersion         = "version"
 	OptionLocalJSON       = "local-json"
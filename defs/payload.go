@@ -0,0 +1,140 @@
+package defs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+)
+
+const (
+	// PayloadRandom uploads a pre-generated block of random data, repeated up to the upload size
+	PayloadRandom = "random"
+	// PayloadPRNG uploads a stream from a fast non-cryptographic random generator, without pre-generated data
+	PayloadPRNG = "prng"
+	// PayloadPattern uploads a small repeating byte pattern
+	PayloadPattern = "pattern"
+	// PayloadZero uploads zeros, to test links compressing the traffic
+	PayloadZero = "zero"
+
+	// maxRandomBlockSize bounds the memory used by the pre-generated random block
+	maxRandomBlockSize = 1 << 20
+	// patternBlockSize is the size of the blocks repeated by the pattern and zero payloads
+	patternBlockSize = 64 * 1024
+)
+
+// PayloadSource creates the bodies of upload requests. Readers are independent from each other, so that concurrent
+// uploads don't share any read position, and the memory used doesn't depend on the upload size
+type PayloadSource interface {
+	// NewReader returns a reader of exactly size bytes
+	NewReader(size int64) io.Reader
+}
+
+// NewPayloadSource creates the payload source of the given kind, for uploads of uploadSize bytes
+func NewPayloadSource(kind string, uploadSize int) (PayloadSource, error) {
+	switch kind {
+	case PayloadRandom, "":
+		blockSize := uploadSize
+		if blockSize > maxRandomBlockSize || blockSize <= 0 {
+			blockSize = maxRandomBlockSize
+		}
+		return &blockPayload{block: getRandomData(blockSize)}, nil
+	case PayloadPRNG:
+		return prngPayload{}, nil
+	case PayloadPattern:
+		block := make([]byte, patternBlockSize)
+		for i := range block {
+			block[i] = byte(i)
+		}
+		return &blockPayload{block: block}, nil
+	case PayloadZero:
+		return &blockPayload{block: make([]byte, patternBlockSize)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported upload payload: %s", kind)
+	}
+}
+
+// blockPayload repeats a block of data, which is only read and therefore shared by all readers
+type blockPayload struct {
+	block []byte
+}
+
+// NewReader implements PayloadSource
+func (p *blockPayload) NewReader(size int64) io.Reader {
+	return &blockReader{block: p.block, remaining: size}
+}
+
+// blockReader reads the block repeatedly until the remaining bytes are read
+type blockReader struct {
+	block     []byte
+	pos       int
+	remaining int64
+}
+
+// Read implements io.Reader
+func (r *blockReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	var n int
+	for n < len(p) {
+		copied := copy(p[n:], r.block[r.pos:])
+		n += copied
+		r.pos = (r.pos + copied) % len(r.block)
+	}
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+// prngPayload generates the data of each reader with its own PCG generator
+type prngPayload struct{}
+
+// NewReader implements PayloadSource
+func (prngPayload) NewReader(size int64) io.Reader {
+	return &prngReader{rng: mrand.NewPCG(mrand.Uint64(), mrand.Uint64()), remaining: size}
+}
+
+// prngReader reads pseudo-random data until the remaining bytes are read
+type prngReader struct {
+	rng       *mrand.PCG
+	buf       [8]byte
+	buffered  int
+	remaining int64
+}
+
+// Read implements io.Reader
+func (r *prngReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n := 0
+	// use the bytes left from the previous read first
+	for r.buffered > 0 && n < len(p) {
+		p[n] = r.buf[8-r.buffered]
+		r.buffered--
+		n++
+	}
+	for ; n+8 <= len(p); n += 8 {
+		binary.LittleEndian.PutUint64(p[n:], r.rng.Uint64())
+	}
+	if n < len(p) {
+		binary.LittleEndian.PutUint64(r.buf[:], r.rng.Uint64())
+		r.buffered = 8
+		for n < len(p) {
+			p[n] = r.buf[8-r.buffered]
+			r.buffered--
+			n++
+		}
+	}
+
+	r.remaining -= int64(n)
+	return n, nil
+}
@@ -2,7 +2,7 @@ package defs
 
 import (
 	"bufio"
-	"encoding/json"
+	"io"
 	"net"
 	"os"
 	"strconv"
@@ -30,46 +30,74 @@ type JSONProgressServerInfo struct {
 	Port     string `json:"port"`
 }
 
+// JSONProgressHeader is sent when the test of a server starts
 type JSONProgressHeader struct {
-	Type      string                    `json:"type"`
-	Timestamp time.Time                 `json:"timestamp"`
+	JSONEvent
 	ISP       string                    `json:"isp"`
 	Server    JSONProgressServerInfo    `json:"server"`
 	Interface JSONProgressInterfaceInfo `json:"interface"`
 }
 
+// JSONProgressPing is sent for every ping of the server
 type JSONProgressPing struct {
-	Type      string    `json:"type"`
-	Timestamp time.Time `json:"timestamp"`
-	Ping      struct {
-		Jitter   float64 `json:"jitter"`
-		Latency  float64 `json:"latency"`
-		Progress float64 `json:"progress"`
+	JSONEvent
+	Ping struct {
+		JitterMs  float64 `json:"jitter_ms"`
+		LatencyMs float64 `json:"latency_ms"`
+		Progress  float64 `json:"progress"`
 	} `json:"ping"`
 }
 
+// JSONProgressDownload is sent periodically during the download test
 type JSONProgressDownload struct {
-	Type      string    `json:"type"`
-	Timestamp time.Time `json:"timestamp"`
-	Download  struct {
-		Bandwidth int     `json:"bandwidth"`
-		Bytes     int     `json:"bytes"`
-		Elapsed   int64   `json:"elapsed"`
-		Progress  float64 `json:"progress"`
+	JSONEvent
+	Download struct {
+		BitrateBps float64 `json:"bitrate_bps"`
+		Bytes      int     `json:"bytes"`
+		ElapsedMs  int64   `json:"elapsed_ms"`
+		Progress   float64 `json:"progress"`
 	} `json:"download"`
 }
 
+// JSONProgressUpload is sent periodically during the upload test
 type JSONProgressUpload struct {
-	Type      string    `json:"type"`
-	Timestamp time.Time `json:"timestamp"`
-	Upload    struct {
-		Bandwidth int     `json:"bandwidth"`
-		Bytes     int     `json:"bytes"`
-		Elapsed   int64   `json:"elapsed"`
-		Progress  float64 `json:"progress"`
+	JSONEvent
+	Upload struct {
+		BitrateBps float64 `json:"bitrate_bps"`
+		Bytes      int     `json:"bytes"`
+		ElapsedMs  int64   `json:"elapsed_ms"`
+		Progress   float64 `json:"progress"`
 	} `json:"upload"`
 }
 
+// JSONProgressServerSelectionStart is sent when probing the servers to select the fastest one starts
+type JSONProgressServerSelectionStart struct {
+	JSONEvent
+	ServerSelection struct {
+		Total int `json:"total"`
+	} `json:"serverSelection"`
+}
+
+// JSONProgressServerSelection is sent whenever a server has been probed
+type JSONProgressServerSelection struct {
+	JSONEvent
+	ServerSelection struct {
+		Probed    int     `json:"probed"`
+		Remaining int     `json:"remaining"`
+		Total     int     `json:"total"`
+		Progress  float64 `json:"progress"`
+	} `json:"serverSelection"`
+}
+
+// JSONProgressServerSelectionResult is sent with the server selected
+type JSONProgressServerSelectionResult struct {
+	JSONEvent
+	Server JSONProgressServerInfo `json:"server"`
+	PingMs float64                `json:"ping_ms"`
+}
+
+// InterfaceStats are the counters of an interface from /proc/net/dev. The kernel doesn't report frame errors and
+// multicast packets for transmission, so TxFrame and TxMulticast are always zero
 type InterfaceStats struct {
 	RxBytes      int64 `json:"rxbytes"`
 	TxBytes      int64 `json:"txbytes"`
@@ -87,93 +115,116 @@ type InterfaceStats struct {
 	TxCompressed int64 `json:"txcompressed"`
 	RxMulticast  int64 `json:"rxmulticast"`
 	TxMulticast  int64 `json:"txmulticast"`
+	TxCollisions int64 `json:"txcollisions"`
+	TxCarrier    int64 `json:"txcarrier"`
 }
 
-func getInterfaceStats(i *net.Interface) InterfaceStats {
-	var result InterfaceStats
+// getInterfaceStats reads the counters of the interface from /proc/net/dev, returning false if they aren't available
+func getInterfaceStats(i *net.Interface) (InterfaceStats, bool) {
 	filename := "/proc/net/dev"
 
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Errorf("error: Failed to open %s (%s)", filename, err)
+		log.Debugf("Failed to open %s: %s", filename, err)
+		return InterfaceStats{}, false
 	}
-
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	return parseInterfaceStats(file, i.Name)
+}
+
+// parseInterfaceStats parses the counters of the named interface from the content of /proc/net/dev, returning false if
+// the interface isn't found or its line is malformed
+func parseInterfaceStats(r io.Reader, name string) (InterfaceStats, bool) {
+	var result InterfaceStats
+	if name == "" {
+		return result, false
+	}
+
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, i.Name+":") {
-			fields := strings.Fields(line)
-			rxfields := fields[1:9]
-			if i, err := strconv.ParseInt(rxfields[0], 10, 64); err == nil {
-				result.RxBytes = (i)
-			}
-			if i, err := strconv.ParseInt(rxfields[1], 10, 64); err == nil {
-				result.RxPackets = (i)
-			}
-			if i, err := strconv.ParseInt(rxfields[2], 10, 64); err == nil {
-				result.RxErrors = (i)
-			}
-			if i, err := strconv.ParseInt(rxfields[3], 10, 64); err == nil {
-				result.RxDropped = (i)
-			}
-			if i, err := strconv.ParseInt(rxfields[4], 10, 64); err == nil {
-				result.RxFifo = (i)
-			}
-			if i, err := strconv.ParseInt(rxfields[5], 10, 64); err == nil {
-				result.RxFrame = (i)
-			}
-			if i, err := strconv.ParseInt(rxfields[6], 10, 64); err == nil {
-				result.RxCompressed = (i)
-			}
-			if i, err := strconv.ParseInt(rxfields[7], 10, 64); err == nil {
-				result.RxMulticast = (i)
-			}
-			txfields := fields[9:]
-			if i, err := strconv.ParseInt(txfields[0], 10, 64); err == nil {
-				result.TxBytes = (i)
-			}
-			if i, err := strconv.ParseInt(txfields[1], 10, 64); err == nil {
-				result.TxPackets = (i)
-			}
-			if i, err := strconv.ParseInt(txfields[2], 10, 64); err == nil {
-				result.TxErrors = (i)
-			}
-			if i, err := strconv.ParseInt(txfields[3], 10, 64); err == nil {
-				result.TxDropped = (i)
-			}
-			if i, err := strconv.ParseInt(txfields[4], 10, 64); err == nil {
-				result.TxFifo = (i)
-			}
-			if i, err := strconv.ParseInt(txfields[5], 10, 64); err == nil {
-				result.TxFrame = (i)
-			}
-			if i, err := strconv.ParseInt(txfields[6], 10, 64); err == nil {
-				result.TxCompressed = (i)
-			}
-			if i, err := strconv.ParseInt(txfields[7], 10, 64); err == nil {
-				result.TxMulticast = (i)
-			}
+		// the counters may directly follow the colon, e.g. "eth0:1234 ..."
+		ifName, counters, found := strings.Cut(scanner.Text(), ":")
+		if !found || strings.TrimSpace(ifName) != name {
+			continue
+		}
 
-			break
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			return result, false
 		}
+
+		values := make([]int64, 16)
+		for idx := range values {
+			// the kernel counters are unsigned
+			v, err := strconv.ParseUint(fields[idx], 10, 63)
+			if err != nil {
+				return result, false
+			}
+			values[idx] = int64(v)
+		}
+
+		result.RxBytes = values[0]
+		result.RxPackets = values[1]
+		result.RxErrors = values[2]
+		result.RxDropped = values[3]
+		result.RxFifo = values[4]
+		result.RxFrame = values[5]
+		result.RxCompressed = values[6]
+		result.RxMulticast = values[7]
+		result.TxBytes = values[8]
+		result.TxPackets = values[9]
+		result.TxErrors = values[10]
+		result.TxDropped = values[11]
+		result.TxFifo = values[12]
+		result.TxCollisions = values[13]
+		result.TxCarrier = values[14]
+		result.TxCompressed = values[15]
+
+		return result, true
 	}
 
-	return result
+	return result, false
+}
+
+// Sub returns the difference of the counters from an earlier reading
+func (s InterfaceStats) Sub(before InterfaceStats) InterfaceStats {
+	return InterfaceStats{
+		RxBytes:      s.RxBytes - before.RxBytes,
+		TxBytes:      s.TxBytes - before.TxBytes,
+		RxPackets:    s.RxPackets - before.RxPackets,
+		TxPackets:    s.TxPackets - before.TxPackets,
+		RxErrors:     s.RxErrors - before.RxErrors,
+		TxErrors:     s.TxErrors - before.TxErrors,
+		RxDropped:    s.RxDropped - before.RxDropped,
+		TxDropped:    s.TxDropped - before.TxDropped,
+		RxFifo:       s.RxFifo - before.RxFifo,
+		TxFifo:       s.TxFifo - before.TxFifo,
+		RxFrame:      s.RxFrame - before.RxFrame,
+		TxFrame:      s.TxFrame - before.TxFrame,
+		RxCompressed: s.RxCompressed - before.RxCompressed,
+		TxCompressed: s.TxCompressed - before.TxCompressed,
+		RxMulticast:  s.RxMulticast - before.RxMulticast,
+		TxMulticast:  s.TxMulticast - before.TxMulticast,
+		TxCollisions: s.TxCollisions - before.TxCollisions,
+		TxCarrier:    s.TxCarrier - before.TxCarrier,
+	}
 }
 
+// GetWanInterface returns the interface of the default route, found by the local address used to reach a public
+// address. It falls back to the first interface that is up and has addresses
 func GetWanInterface() net.Interface {
-	var result net.Interface
+	for _, target := range []string{"1.1.1.1", "2606:4700:4700::1111"} {
+		if iface, ok := routeInterface(target); ok {
+			return iface
+		}
+	}
 
+	var result net.Interface
 	ifs, _ := net.Interfaces()
 	for i := 0; i < len(ifs); i++ {
-		if ifs[i].Name == "eth0" {
-			result = ifs[i]
-			break
-		} else if ifs[i].Flags&net.FlagUp != 0 && ifs[i].Flags&net.FlagLoopback == 0 {
-			ips, _ := ifs[i].Addrs()
-			if len(ips) > 1 {
+		if ifs[i].Flags&net.FlagUp != 0 && ifs[i].Flags&net.FlagLoopback == 0 {
+			if ips, _ := ifs[i].Addrs(); len(ips) > 0 {
 				result = ifs[i]
 				break
 			}
@@ -185,6 +236,11 @@ func GetWanInterface() net.Interface {
 
 func SendProgressHeader(s *Server, isp *IPInfoResponse) {
 	getIPFromInterface := func(iface *net.Interface) string {
+		// the local address of the connection to the server is the most accurate
+		if s.LocalIP != "" {
+			return s.LocalIP
+		}
+
 		// find the wan IP address
 		var ipAddr net.IP
 		if addrs, err := iface.Addrs(); err == nil {
@@ -199,85 +255,101 @@ func SendProgressHeader(s *Server, isp *IPInfoResponse) {
 	}
 
 	var header JSONProgressHeader
-	wanInterface := GetWanInterface()
+	wanInterface := s.WanInterface()
 
-	header.Timestamp = time.Now()
-	header.Type = "testStart"
 	header.ISP = isp.Organization
 	header.Interface.ExternalIP = isp.IP
 	header.Interface.InternalIP = getIPFromInterface(&wanInterface)
-	header.Interface.IsVpn = false
+	header.Interface.IsVpn = IsVpnInterface(&wanInterface)
 	header.Interface.MacAddr = wanInterface.HardwareAddr.String()
 	header.Interface.Name = wanInterface.Name
 
+	header.Server = progressServerInfo(s)
+
+	SendEvent("testStart", &header)
+}
+
+// progressServerInfo returns the information of the server sent in events
+func progressServerInfo(s *Server) JSONProgressServerInfo {
 	serverUrl, _ := s.GetURL()
-	header.Server.Name = s.Name
-	header.Server.ID = s.ID
-	header.Server.Host = serverUrl.Hostname()
-	header.Server.Port = serverUrl.Port()
-	header.Server.IP = serverUrl.Hostname()
-	header.Server.Country = s.Country
-	header.Server.Location = s.Location
-
-	if b, err := json.Marshal(&header); err != nil {
-		log.Errorf("Error generating progress update: %s", err)
-	} else {
-		log.Warnf("%s", b)
+	return JSONProgressServerInfo{
+		Name:     s.Name,
+		ID:       s.ID,
+		Host:     serverUrl.Hostname(),
+		Port:     serverUrl.Port(),
+		IP:       s.IP,
+		Country:  s.Country,
+		Location: s.Location,
+	}
+}
+
+// SendServerSelectionStart sends the start of the server selection among `total` servers
+func SendServerSelectionStart(total int) {
+	var progress JSONProgressServerSelectionStart
+	progress.ServerSelection.Total = total
+
+	SendEvent("serverSelectionStart", &progress)
+}
+
+// SendServerSelectionResult sends the server selected, and its ping
+func SendServerSelectionResult(s *Server, ping float64) {
+	var result JSONProgressServerSelectionResult
+	result.Server = progressServerInfo(s)
+	result.PingMs = ping
+
+	SendEvent("serverSelectionResult", &result)
+}
+
+func SendServerSelectionProgress(probed, total int) {
+	var progress JSONProgressServerSelection
+	progress.ServerSelection.Probed = probed
+	progress.ServerSelection.Remaining = total - probed
+	progress.ServerSelection.Total = total
+	if total > 0 {
+		progress.ServerSelection.Progress = float64(probed) / float64(total)
 	}
+
+	SendEvent("serverSelection", &progress)
 }
 
 func SendPingProgress(latency float64, jitter float64, progress float64) {
 	var pingProgress JSONProgressPing
-	pingProgress.Timestamp = time.Now()
-	pingProgress.Type = "ping"
-	pingProgress.Ping.Latency = latency
-	pingProgress.Ping.Jitter = jitter
+	pingProgress.Ping.LatencyMs = latency
+	pingProgress.Ping.JitterMs = jitter
 	pingProgress.Ping.Progress = progress
 
-	if b, err := json.Marshal(&pingProgress); err != nil {
-		log.Errorf("Error generating progress update: %s", err)
-	} else {
-		log.Warnf("%s", b)
-	}
+	SendEvent("ping", &pingProgress)
 }
 
 func SendDownloadProgress(c *BytesCounter, durationMs int64) {
 	var progress JSONProgressDownload
 
-	progress.Timestamp = time.Now()
-	progress.Type = "download"
-	progress.Download.Bytes = c.total
-	progress.Download.Elapsed = time.Since(c.start).Milliseconds()
-	progress.Download.Bandwidth = int(float64(progress.Download.Bytes) / (float64(time.Since(c.start).Milliseconds()) / 1000))
-	progress.Download.Progress = float64(progress.Download.Elapsed) / float64(durationMs)
+	progress.Download.Bytes = c.Total()
+	progress.Download.ElapsedMs = time.Since(c.start).Milliseconds()
+	if progress.Download.ElapsedMs > 0 {
+		progress.Download.BitrateBps = float64(progress.Download.Bytes) * 8 / (float64(progress.Download.ElapsedMs) / 1000)
+	}
+	progress.Download.Progress = float64(progress.Download.ElapsedMs) / float64(durationMs)
 	if progress.Download.Progress > 1 {
 		progress.Download.Progress = 1
 	}
 
-	if b, err := json.Marshal(&progress); err != nil {
-		log.Errorf("Error generating progress update: %s", err)
-	} else {
-		log.Warnf("%s", b)
-	}
+	SendEvent("download", &progress)
 }
 
 // TODO: set durationMs once instead of with every progress update since it's constant
 func SendUploadProgress(c *BytesCounter, durationMs int64) {
 	var progress JSONProgressUpload
 
-	progress.Timestamp = time.Now()
-	progress.Type = "upload"
-	progress.Upload.Bytes = c.total
-	progress.Upload.Elapsed = time.Since(c.start).Milliseconds()
-	progress.Upload.Bandwidth = int(float64(progress.Upload.Bytes) / (float64(time.Since(c.start).Milliseconds()) / 1000))
-	progress.Upload.Progress = float64(progress.Upload.Elapsed) / float64(durationMs)
+	progress.Upload.Bytes = c.Total()
+	progress.Upload.ElapsedMs = time.Since(c.start).Milliseconds()
+	if progress.Upload.ElapsedMs > 0 {
+		progress.Upload.BitrateBps = float64(progress.Upload.Bytes) * 8 / (float64(progress.Upload.ElapsedMs) / 1000)
+	}
+	progress.Upload.Progress = float64(progress.Upload.ElapsedMs) / float64(durationMs)
 	if progress.Upload.Progress > 1 {
 		progress.Upload.Progress = 1
 	}
 
-	if b, err := json.Marshal(&progress); err != nil {
-		log.Errorf("Error generating progress update: %s", err)
-	} else {
-		log.Warnf("%s", b)
-	}
+	SendEvent("upload", &progress)
 }
@@ -0,0 +1,101 @@
+package defs
+
+import (
+	"strings"
+	"testing"
+)
+
+const procNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:  123456     789    0    0    0     0          0         0   123456     789    0    0    0     0       0          0
+  eth0: 1000 2000 3 4 5 6 7 8 9000 10000 11 12 13 14 15 16
+wlan0:98765 4321 0 1 0 0 0 2 56789 1234 0 0 0 0 0 0
+`
+
+func TestParseInterfaceStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		iface string
+		want  InterfaceStats
+		ok    bool
+	}{
+		{
+			name:  "proc net dev",
+			input: procNetDev,
+			iface: "eth0",
+			want: InterfaceStats{
+				RxBytes: 1000, RxPackets: 2000, RxErrors: 3, RxDropped: 4, RxFifo: 5, RxFrame: 6, RxCompressed: 7,
+				RxMulticast: 8, TxBytes: 9000, TxPackets: 10000, TxErrors: 11, TxDropped: 12, TxFifo: 13, TxCollisions: 14,
+				TxCarrier: 15, TxCompressed: 16,
+			},
+			ok: true,
+		},
+		{
+			name:  "loopback",
+			input: procNetDev,
+			iface: "lo",
+			want:  InterfaceStats{RxBytes: 123456, RxPackets: 789, TxBytes: 123456, TxPackets: 789},
+			ok:    true,
+		},
+		{
+			name:  "no space after colon",
+			input: procNetDev,
+			iface: "wlan0",
+			want:  InterfaceStats{RxBytes: 98765, RxPackets: 4321, RxDropped: 1, RxMulticast: 2, TxBytes: 56789, TxPackets: 1234},
+			ok:    true,
+		},
+		{
+			name:  "missing interface",
+			input: procNetDev,
+			iface: "eth1",
+		},
+		{
+			name:  "prefix of an interface",
+			input: procNetDev,
+			iface: "eth",
+		},
+		{
+			name:  "empty name",
+			input: procNetDev,
+			iface: "",
+		},
+		{
+			name:  "short line",
+			input: "eth0: 1 2 3 4 5 6 7 8 9 10\n",
+			iface: "eth0",
+		},
+		{
+			name:  "no counters",
+			input: "eth0:\n",
+			iface: "eth0",
+		},
+		{
+			name:  "non-numeric counter",
+			input: "eth0: 1 2 3 4 5 6 7 8 9 10 11 12 13 x 15 16\n",
+			iface: "eth0",
+		},
+		{
+			name:  "negative counter",
+			input: "eth0: 1 2 3 4 5 6 7 8 9 10 11 12 13 -14 15 16\n",
+			iface: "eth0",
+		},
+		{
+			name:  "empty input",
+			input: "",
+			iface: "eth0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseInterfaceStats(strings.NewReader(tt.input), tt.iface)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package defs
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	// redacted replaces secrets in logs
+	redacted = "xxxxx"
+
+	// minSecretLength is the length below which values aren't redacted, as replacing them would garble the logs
+	minSecretLength = 4
+)
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// AddSecret registers a value, like a password or token, to be redacted from logs and telemetry
+func AddSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// Redact replaces the registered secrets in the string
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
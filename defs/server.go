@@ -2,22 +2,25 @@ package defs
 
 import (
 	"context"
-	"crypto/rand"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"path"
 	"strconv"
 	"time"
 
-	"github.com/briandowns/spinner"
 	"github.com/go-ping/ping"
 	log "github.com/sirupsen/logrus"
+
+	"librespeed-cli/units"
 )
 
 // Server represents a speed test server
@@ -33,45 +36,101 @@ type Server struct {
 	SponsorURL  string `json:"sponsorURL"`
 	Location    string `json:"location"`
 	Country     string `json:"country"`
-
-	NoICMP              bool         `json:"-"`
-	IncrementalProgress bool         `json:"-"`
-	TLog                TelemetryLog `json:"-"`
+	// PinSHA256 are the base64 SHA-256 hashes of the public keys the server's certificate can have
+	PinSHA256 []string `json:"pinSha256,omitempty"`
+	// Headers are added to the requests to the server, overriding the ones given with --header
+	Headers map[string]string `json:"headers,omitempty"`
+
+	Source              string `json:"-"`
+	Protocol            string `json:"-"`
+	TLSVersion          string `json:"-"`
+	TLSCipher           string `json:"-"`
+	IP                  string `json:"-"`
+	Interface           string `json:"-"`
+	LocalIP             string `json:"-"`
+	NoICMP              bool   `json:"-"`
+	IncrementalProgress bool   `json:"-"`
+	// PacketLoss is the percentage of ICMP echos lost by the last ping, -1 when pinged over HTTP
+	PacketLoss float64 `json:"-"`
+	// MaxBytes caps the bytes of each download and upload, 0 for no cap
+	MaxBytes int64 `json:"-"`
+	// RateLimit throttles downloads and uploads, in bytes per second, 0 for no limit
+	RateLimit float64      `json:"-"`
+	TLog      TelemetryLog `json:"-"`
 }
 
-// IsUp checks the speed test backend is up by accessing the ping URL
-func (s *Server) IsUp() bool {
+// IsUp checks the speed test backend is up by accessing the ping URL, returning why it isn't
+func (s *Server) IsUp(ctx context.Context) error {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("Check backend is up took %s", time.Now().Sub(t).String())
 	}()
 
-	u, _ := s.GetURL()
+	u, err := s.GetURL()
+	if err != nil {
+		return err
+	}
 	u.Path = path.Join(u.Path, s.PingURL)
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		log.Debugf("Failed when creating HTTP request: %s", err)
-		return false
+		return err
 	}
 	req.Header.Set("User-Agent", UserAgent)
 
+	// record the IP address actually connected to, and the local address connected from. HTTP/3 connections are over
+	// UDP, from a socket shared by all connections that is usually bound to the unspecified address, in which case the
+	// interface is found from the route to the server
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if ip := addrIP(info.Conn.RemoteAddr()); ip != nil {
+				s.IP = ip.String()
+			}
+			if ip := addrIP(info.Conn.LocalAddr()); ip != nil && !ip.IsUnspecified() {
+				s.LocalIP = ip.String()
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Debugf("Error checking for server status: %s", err)
-		return false
+		return err
 	}
 	defer resp.Body.Close()
 	b, _ := ioutil.ReadAll(resp.Body)
 	if len(b) > 0 {
 		log.Debugf("Failed when parsing get IP result: %s", b)
 	}
+	// record the negotiated HTTP and TLS versions
+	s.Protocol = resp.Proto
+	if resp.TLS != nil {
+		s.TLSVersion = tls.VersionName(resp.TLS.Version)
+		s.TLSCipher = tls.CipherSuiteName(resp.TLS.CipherSuite)
+	}
+
 	// only return online if the ping URL returns nothing and 200
-	return resp.StatusCode == http.StatusOK
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// addrIP returns the IP address of a TCP or UDP address
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
 }
 
 // ICMPPingAndJitter pings the server via ICMP echos and calculate the average ping and jitter
-func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, float64, error) {
+func (s *Server) ICMPPingAndJitter(ctx context.Context, count int, srcIp, network string) (float64, float64, error) {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("ICMP ping took %s", time.Now().Sub(t).String())
@@ -79,7 +138,7 @@ func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, f
 
 	if s.NoICMP {
 		log.Debugf("Skipping ICMP for server %s, will use HTTP ping", s.Name)
-		return s.PingAndJitter(count + 2)
+		return s.PingAndJitter(ctx, count+2)
 	}
 
 	u, err := s.GetURL()
@@ -88,20 +147,59 @@ func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, f
 		return 0, 0, err
 	}
 
-	p := ping.New(u.Hostname())
+	// ping the address HTTP requests are connected to, so that --dns-server and --resolve are honored
+	host := u.Hostname()
+	if s.IP != "" {
+		host = s.IP
+	}
+
+	p := ping.New(host)
 	p.SetNetwork(network)
 	p.Count = count
 	p.Timeout = time.Duration(count) * time.Second
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < p.Timeout {
+		p.Timeout = time.Until(deadline)
+	}
 	if srcIp != "" {
 		p.Source = srcIp
+	} else if s.Interface != "" {
+		// ICMP sockets can't be bound to an interface, use its address of the same IP version as the server instead
+		if err := p.Resolve(); err != nil {
+			log.Debugf("Failed to resolve target host: %s", err)
+			return s.PingAndJitter(ctx, count+2)
+		}
+		if p.Source = interfaceAddr(s.Interface, p.IPAddr().IP.To4() != nil); p.Source == "" {
+			log.Debugf("Interface %s has no address to ping %s from, will use HTTP ping", s.Interface, s.Name)
+			return s.PingAndJitter(ctx, count+2)
+		}
 	}
 	if log.GetLevel() == log.DebugLevel {
 		p.Debug = true
 	}
+	// stop pinging when the context is cancelled
+	pingDone := make(chan struct{})
+	defer close(pingDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Stop()
+		case <-pingDone:
+		}
+	}()
+
+	// send the ping progress in JSONL mode as the replies arrive, sub-millisecond RTTs on LANs aren't truncated to 0
+	if s.IncrementalProgress {
+		var rtts []float64
+		p.OnRecv = func(pkt *ping.Packet) {
+			rtts = append(rtts, float64(pkt.Rtt)/float64(time.Millisecond))
+			SendPingProgress(rtts[len(rtts)-1], getJitter(rtts), float64(len(rtts))/float64(count))
+		}
+	}
+
 	if err := p.Run(); err != nil {
 		log.Debugf("Failed to ping target host: %s", err)
 		log.Debug("Will try TCP ping")
-		return s.PingAndJitter(count + 2)
+		return s.PingAndJitter(ctx, count+2)
 	}
 
 	stats := p.Statistics()
@@ -109,7 +207,7 @@ func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, f
 	var lastPing, jitter float64
 	for idx, rtt := range stats.Rtts {
 		if idx != 0 {
-			instJitter := math.Abs(lastPing - float64(rtt.Milliseconds()))
+			instJitter := math.Abs(lastPing - float64(rtt)/float64(time.Millisecond))
 			if idx > 1 {
 				if jitter > instJitter {
 					jitter = jitter*0.7 + instJitter*0.3
@@ -118,16 +216,17 @@ func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, f
 				}
 			}
 		}
-		lastPing = float64(rtt.Milliseconds())
+		lastPing = float64(rtt) / float64(time.Millisecond)
 	}
 
 	if len(stats.Rtts) == 0 {
 		s.NoICMP = true
 		log.Debugf("No ICMP pings returned for server %s (%s), trying TCP ping", s.Name, u.Hostname())
-		return s.PingAndJitter(count + 2)
+		return s.PingAndJitter(ctx, count+2)
 	}
 
-	return float64(stats.AvgRtt.Milliseconds()), jitter, nil
+	s.PacketLoss = stats.PacketLoss
+	return float64(stats.AvgRtt) / float64(time.Millisecond), jitter, nil
 }
 
 func getJitter(pings []float64) float64 {
@@ -150,7 +249,7 @@ func getJitter(pings []float64) float64 {
 }
 
 // PingAndJitter pings the server via accessing ping URL and calculate the average ping and jitter
-func (s *Server) PingAndJitter(count int) (float64, float64, error) {
+func (s *Server) PingAndJitter(ctx context.Context, count int) (float64, float64, error) {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("TCP ping took %s", time.Now().Sub(t).String())
@@ -163,9 +262,11 @@ func (s *Server) PingAndJitter(count int) (float64, float64, error) {
 	}
 	u.Path = path.Join(u.Path, s.PingURL)
 
+	// failed requests end the ping, losses aren't measured
+	s.PacketLoss = -1
 	var pings []float64
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		log.Debugf("Failed when creating HTTP request: %s", err)
 		return 0, 0, err
@@ -183,7 +284,7 @@ func (s *Server) PingAndJitter(count int) (float64, float64, error) {
 		resp.Body.Close()
 		end := time.Now()
 
-		pings = append(pings, float64(end.Sub(start).Milliseconds()))
+		pings = append(pings, float64(end.Sub(start))/float64(time.Millisecond))
 
 		if i > 0 && s.IncrementalProgress {
 			SendPingProgress(pings[len(pings)-1], getJitter(pings[1:]), float64(i)/float64(count))
@@ -206,7 +307,6 @@ func (s *Server) Download(silent bool, useBytes, useMebi bool, requests int, chu
 	}()
 
 	counter := NewCounter()
-	counter.SetMebi(useMebi)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -218,8 +318,8 @@ func (s *Server) Download(silent bool, useBytes, useMebi bool, requests int, chu
 	}
 
 	// track the interface stats before starting the download
-	wanInterface := GetWanInterface()
-	statsBefore := getInterfaceStats(&wanInterface)
+	wanInterface := s.WanInterface()
+	statsBefore, statsOk := getInterfaceStats(&wanInterface)
 
 	u.Path = path.Join(u.Path, s.DownloadURL)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
@@ -227,6 +327,8 @@ func (s *Server) Download(silent bool, useBytes, useMebi bool, requests int, chu
 		log.Debugf("Failed when creating HTTP request: %s", err)
 		return TransferSummaryResponse{}, err
 	}
+	limiter := NewTransferLimiter(s.MaxBytes, s.RateLimit)
+
 	q := req.URL.Query()
 	q.Set("ckSize", strconv.Itoa(chunks))
 	req.URL.RawQuery = q.Encode()
@@ -235,25 +337,29 @@ func (s *Server) Download(silent bool, useBytes, useMebi bool, requests int, chu
 
 	downloadDone := make(chan struct{}, requests)
 
+	// every stream sends a request of its own
 	doDownload := func() {
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := http.DefaultClient.Do(req.Clone(ctx))
 		if err != nil {
 			log.Debugf("Failed when making HTTP request: %s", err)
 		} else {
 			defer resp.Body.Close()
 
-			if _, err = io.Copy(ioutil.Discard, io.TeeReader(resp.Body, counter)); err != nil {
+			if _, err = io.Copy(ioutil.Discard, io.TeeReader(limiter.Reader(ctx, resp.Body), counter)); err != nil {
 				if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
 					log.Debugf("Failed when reading HTTP response: %s", err)
 				}
 			}
 
-			downloadDone <- struct{}{}
+			select {
+			case downloadDone <- struct{}{}:
+			case <-ctx.Done():
+			}
 		}
 	}
 
 	updateProgress := func() {
-		for time.Since(counter.start).Milliseconds() < duration.Milliseconds() {
+		for ctx.Err() == nil && time.Since(counter.start).Milliseconds() < duration.Milliseconds() {
 			time.Sleep(100 * time.Millisecond)
 
 			SendDownloadProgress(counter, duration.Milliseconds())
@@ -262,24 +368,13 @@ func (s *Server) Download(silent bool, useBytes, useMebi bool, requests int, chu
 
 	counter.Start()
 	if !silent {
-		pb := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
-		pb.Prefix = "Downloading...  "
-		pb.PostUpdate = func(s *spinner.Spinner) {
-			if useBytes {
-				s.Suffix = fmt.Sprintf("  %s", counter.AvgHumanize())
-			} else {
-				s.Suffix = fmt.Sprintf("  %.2f Mbps", counter.AvgMbps())
-			}
-		}
-
+		u := units.Units{Bytes: useBytes, IEC: useMebi}
+		pb := NewProgress("Downloading...", func() string {
+			return u.Rate(counter.AvgBps())
+		})
 		pb.Start()
 		defer func() {
-			if useBytes {
-				pb.FinalMSG = fmt.Sprintf("Download rate:\t%s\n", counter.AvgHumanize())
-			} else {
-				pb.FinalMSG = fmt.Sprintf("Download rate:\t%.2f Mbps\n", counter.AvgMbps())
-			}
-			pb.Stop()
+			pb.Stop(fmt.Sprintf("Download rate:\t%s\n", u.Rate(counter.AvgBps())))
 		}()
 	}
 
@@ -292,47 +387,47 @@ func (s *Server) Download(silent bool, useBytes, useMebi bool, requests int, chu
 		time.Sleep(200 * time.Millisecond)
 	}
 	timeout := time.After(duration)
+	endReason := EndReasonDuration
 Loop:
 	for {
 		select {
 		case <-timeout:
-			ctx.Done()
+			break Loop
+		case <-limiter.Reached():
+			endReason = EndReasonByteCap
 			break Loop
 		case <-downloadDone:
 			go doDownload()
 		}
 	}
-
-	// get the final interface stats for the download
-	statsAfter := getInterfaceStats(&wanInterface)
+	// stop the requests in flight
+	cancel()
 
 	downloadResult := TransferSummaryResponse{
-		Bitrate:      counter.AvgMbps(),
-		TotalBytes:   counter.Total(),
-		TotalPackets: int(statsAfter.RxPackets - statsBefore.RxPackets),
-		Elapsed:      time.Since(counter.start).Milliseconds(),
+		Bitrate:    units.Units{IEC: useMebi}.Mbps(counter.AvgBps()),
+		TotalBytes: counter.Total(),
+		Elapsed:    time.Since(counter.start).Milliseconds(),
+		EndReason:  endReason,
+	}
+
+	// get the final interface stats for the download
+	if statsAfter, ok := getInterfaceStats(&wanInterface); ok && statsOk {
+		downloadResult.Interface = newInterfaceCounters(&wanInterface, statsBefore, statsAfter, downloadResult.TotalBytes, true)
+		downloadResult.TotalPackets = int(downloadResult.Interface.Delta.RxPackets)
 	}
 
 	return downloadResult, nil
 }
 
-// Upload performs the actual upload test
-func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests int, uploadSize int, duration time.Duration) (TransferSummaryResponse, error) {
+// Upload performs the actual upload test, each request sending uploadSize KiB from the payload source
+func (s *Server) Upload(payload PayloadSource, silent, useBytes, useMebi bool, requests int, uploadSize int, duration time.Duration) (TransferSummaryResponse, error) {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("Upload took %s", time.Now().Sub(t).String())
 	}()
 
 	counter := NewCounter()
-	counter.SetMebi(useMebi)
-	counter.SetUploadSize(uploadSize)
-
-	if noPrealloc {
-		log.Info("Pre-allocation is disabled, performance might be lower!")
-		counter.reader = &SeekWrapper{rand.Reader}
-	} else {
-		counter.GenerateBlob()
-	}
+	size := int64(uploadSize) * 1024
 
 	u, err := s.GetURL()
 	if err != nil {
@@ -340,24 +435,46 @@ func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests int
 		return TransferSummaryResponse{}, err
 	}
 
-	// track the interface stats before starting the download
-	wanInterface := GetWanInterface()
-	statsBefore := getInterfaceStats(&wanInterface)
+	// track the interface stats before starting the upload
+	wanInterface := s.WanInterface()
+	statsBefore, statsOk := getInterfaceStats(&wanInterface)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	u.Path = path.Join(u.Path, s.UploadURL)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), counter)
-	if err != nil {
+	limiter := NewTransferLimiter(s.MaxBytes, s.RateLimit)
+
+	// every request gets a body of its own, counting the bytes read by the transport
+	newBody := func() io.ReadCloser {
+		return ioutil.NopCloser(limiter.Reader(ctx, counter.Reader(payload.NewReader(size))))
+	}
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), newBody())
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		req.GetBody = func() (io.ReadCloser, error) {
+			return newBody(), nil
+		}
+		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("Accept-Encoding", "identity")
+		return req, nil
+	}
+	if _, err := newRequest(); err != nil {
 		log.Debugf("Failed when creating HTTP request: %s", err)
 		return TransferSummaryResponse{}, err
 	}
-	req.Header.Set("User-Agent", UserAgent)
-	req.Header.Set("Accept-Encoding", "identity")
 
 	uploadDone := make(chan struct{}, requests)
 
 	doUpload := func() {
+		req, err := newRequest()
+		if err != nil {
+			log.Debugf("Failed when creating HTTP request: %s", err)
+			return
+		}
+
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
 			log.Debugf("Failed when making HTTP request: %s", err)
@@ -367,12 +484,15 @@ func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests int
 				log.Debugf("Failed when reading HTTP response: %s", err)
 			}
 
-			uploadDone <- struct{}{}
+			select {
+			case uploadDone <- struct{}{}:
+			case <-ctx.Done():
+			}
 		}
 	}
 
 	updateProgress := func() {
-		for time.Since(counter.start).Milliseconds() < duration.Milliseconds() {
+		for ctx.Err() == nil && time.Since(counter.start).Milliseconds() < duration.Milliseconds() {
 			time.Sleep(100 * time.Millisecond)
 
 			SendUploadProgress(counter, duration.Milliseconds())
@@ -381,24 +501,13 @@ func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests int
 
 	counter.Start()
 	if !silent {
-		pb := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
-		pb.Prefix = "Uploading...  "
-		pb.PostUpdate = func(s *spinner.Spinner) {
-			if useBytes {
-				s.Suffix = fmt.Sprintf("  %s", counter.AvgHumanize())
-			} else {
-				s.Suffix = fmt.Sprintf("  %.2f Mbps", counter.AvgMbps())
-			}
-		}
-
+		u := units.Units{Bytes: useBytes, IEC: useMebi}
+		pb := NewProgress("Uploading...", func() string {
+			return u.Rate(counter.AvgBps())
+		})
 		pb.Start()
 		defer func() {
-			if useBytes {
-				pb.FinalMSG = fmt.Sprintf("Upload rate:\t%s\n", counter.AvgHumanize())
-			} else {
-				pb.FinalMSG = fmt.Sprintf("Upload rate:\t%.2f Mbps\n", counter.AvgMbps())
-			}
-			pb.Stop()
+			pb.Stop(fmt.Sprintf("Upload rate:\t%s\n", u.Rate(counter.AvgBps())))
 		}()
 	}
 
@@ -411,31 +520,40 @@ func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests int
 		time.Sleep(200 * time.Millisecond)
 	}
 	timeout := time.After(duration)
+	endReason := EndReasonDuration
 Loop:
 	for {
 		select {
 		case <-timeout:
-			ctx.Done()
+			break Loop
+		case <-limiter.Reached():
+			endReason = EndReasonByteCap
 			break Loop
 		case <-uploadDone:
 			go doUpload()
 		}
 	}
+	// stop the requests in flight
+	cancel()
 
-	// get the final interface stats for the download
-	statsAfter := getInterfaceStats(&wanInterface)
 	uploadResult := TransferSummaryResponse{
-		Bitrate:      counter.AvgMbps(),
-		TotalBytes:   counter.Total(),
-		TotalPackets: int(statsAfter.TxPackets - statsBefore.TxPackets),
-		Elapsed:      time.Since(counter.start).Milliseconds(),
+		Bitrate:    units.Units{IEC: useMebi}.Mbps(counter.AvgBps()),
+		TotalBytes: counter.Total(),
+		Elapsed:    time.Since(counter.start).Milliseconds(),
+		EndReason:  endReason,
+	}
+
+	// get the final interface stats for the upload
+	if statsAfter, ok := getInterfaceStats(&wanInterface); ok && statsOk {
+		uploadResult.Interface = newInterfaceCounters(&wanInterface, statsBefore, statsAfter, uploadResult.TotalBytes, false)
+		uploadResult.TotalPackets = int(uploadResult.Interface.Delta.TxPackets)
 	}
 
 	return uploadResult, nil
 }
 
 // GetIPInfo accesses the backend's getIP.php endpoint and get current client's IP information
-func (s *Server) GetIPInfo(distanceUnit string) (*GetIPResult, error) {
+func (s *Server) GetIPInfo(ctx context.Context, distanceUnit string) (*GetIPResult, error) {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("Get IP info took %s", time.Now().Sub(t).String())
@@ -453,7 +571,7 @@ func (s *Server) GetIPInfo(distanceUnit string) (*GetIPResult, error) {
 	q.Set("distance", distanceUnit)
 	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		log.Debugf("Failed when creating HTTP request: %s", err)
 		return nil, err
@@ -0,0 +1,70 @@
+package defs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpload(t *testing.T) {
+	const (
+		streams    = 3
+		uploadSize = 64
+		size       = uploadSize * 1024
+	)
+
+	var (
+		mu       sync.Mutex
+		requests int
+		complete int
+		received int64
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if r.ContentLength != size {
+			t.Errorf("request %d: Content-Length %d, want %d", requests, r.ContentLength, size)
+		}
+		received += n
+		if err == nil {
+			if n != size {
+				t.Errorf("request %d: received %d bytes, want %d", requests, n, size)
+			}
+			complete++
+		}
+	}))
+	defer ts.Close()
+
+	payload, err := NewPayloadSource(PayloadRandom, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Server: ts.URL + "/", UploadURL: "empty"}
+	result, err := s.Upload(payload, true, false, false, streams, uploadSize, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// let the server handle the requests cancelled at the end
+	ts.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if complete < streams {
+		t.Fatalf("%d uploads completed, want at least %d", complete, streams)
+	}
+	// the bytes of the requests cancelled in flight are counted once read, even if the server didn't get them. There is
+	// at most a request in flight per stream
+	if int64(result.TotalBytes) < received || result.TotalBytes > (requests+streams)*size {
+		t.Errorf("counted %d bytes, the server received %d bytes in %d requests", result.TotalBytes, received, requests)
+	}
+	if result.Bitrate <= 0 {
+		t.Errorf("bitrate %f, want a positive bitrate", result.Bitrate)
+	}
+}
@@ -0,0 +1,102 @@
+package defs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/spinner"
+	log "github.com/sirupsen/logrus"
+)
+
+// progressInterval is the interval of the progress lines when the progress isn't shown on a terminal
+const progressInterval = 2 * time.Second
+
+var (
+	progressMu       sync.Mutex
+	progressTerminal = true
+)
+
+// SetProgressTerminal sets whether the progress is shown on a terminal, with spinners, or as plain lines, e.g. when
+// redirected to a file
+func SetProgressTerminal(terminal bool) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+
+	progressTerminal = terminal
+}
+
+// Progress shows the progress of a step on the log output: a spinner on a terminal, or a line every progressInterval
+// otherwise, without control characters
+type Progress struct {
+	prefix string
+	status func() string
+
+	spinner *spinner.Spinner
+	out     io.Writer
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewProgress returns the progress of a step, showing the prefix followed by the status if not nil
+func NewProgress(prefix string, status func() string) *Progress {
+	return &Progress{prefix: prefix, status: status}
+}
+
+// Start starts showing the progress
+func (p *Progress) Start() {
+	p.out = log.StandardLogger().Out
+
+	progressMu.Lock()
+	terminal := progressTerminal
+	progressMu.Unlock()
+
+	if terminal {
+		p.spinner = spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriter(p.out))
+		p.spinner.Prefix = p.prefix + "  "
+		if p.status != nil {
+			p.spinner.PostUpdate = func(s *spinner.Spinner) {
+				s.Suffix = "  " + p.status()
+			}
+		}
+		p.spinner.Start()
+		return
+	}
+
+	fmt.Fprintln(p.out, p.prefix)
+	if p.status == nil {
+		return
+	}
+	p.done = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				fmt.Fprintf(p.out, "%s: %s\n", strings.TrimSuffix(p.prefix, "..."), p.status())
+			}
+		}
+	}()
+}
+
+// Stop stops showing the progress, and shows the final message in its place
+func (p *Progress) Stop(final string) {
+	if p.spinner != nil {
+		p.spinner.FinalMSG = final
+		p.spinner.Stop()
+		return
+	}
+
+	if p.done != nil {
+		close(p.done)
+		p.wg.Wait()
+	}
+	io.WriteString(p.out, final)
+}
@@ -47,9 +47,9 @@ func (t *TelemetryLog) Verbosef(format string, a ...interface{}) {
 	}
 }
 
-// String returns the concatenated string of field `content`
+// String returns the concatenated string of field `content`, with secrets redacted
 func (t *TelemetryLog) String() string {
-	return strings.Join(t.content, "\n")
+	return Redact(strings.Join(t.content, "\n"))
 }
 
 // TelemetryExtra represents the `extra` field in the telemetry data
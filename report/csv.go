@@ -1,18 +1,230 @@
 package report
 
 import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"librespeed-cli/units"
 )
 
-// CSVReport represents the output data fields in a CSV file
-type CSVReport struct {
-	Timestamp time.Time `csv:"Timestamp"`
-	Name      string    `csv:"Server Name"`
-	Address   string    `csv:"Address"`
-	Ping      float64   `csv:"Ping"`
-	Jitter    float64   `csv:"Jitter"`
-	Download  float64   `csv:"Download"`
-	Upload    float64   `csv:"Upload"`
-	Share     string    `csv:"Share"`
-	IP        string    `csv:"IP"`
+// csvColumn is a column of the CSV format, with the title of its header and the field of the results it shows
+type csvColumn struct {
+	title string
+	field string
+}
+
+// defaultCSVColumns are the columns written when no fields are given
+var defaultCSVColumns = []csvColumn{
+	{"Timestamp", "timestamp"},
+	{"Server Name", "server.name"},
+	{"Address", "server.url"},
+	{"Ping", "ping_ms"},
+	{"Jitter", "jitter_ms"},
+	{"Download", "download_mbps"},
+	{"Upload", "upload_mbps"},
+	{"Share", "share"},
+	{"IP", "client.ip"},
+}
+
+// csvField returns the value of a field of a result as written in the CSV format
+type csvField func(rep JSONReport, opts Options) string
+
+// csvFields are the fields of the results available as CSV columns, by name, and csvFieldNames their names in the
+// order of the JSON report
+var csvFields, csvFieldNames = newCSVFields()
+
+func init() {
+	Register(FormatCSV, newCSVWriter)
+	Register(FormatTSV, func(w io.Writer, opts Options) Writer {
+		opts.Delimiter = '\t'
+		return newCSVWriter(w, opts)
+	})
+}
+
+// CSVFields returns the names of the fields available as CSV columns
+func CSVFields() []string {
+	return csvFieldNames
+}
+
+// CheckCSVFields returns an error if a field isn't available as a CSV column
+func CheckCSVFields(names []string) error {
+	for _, name := range names {
+		if _, ok := csvFields[name]; !ok {
+			return fmt.Errorf("unknown CSV field %q, fields are named as in the JSON output with nested fields joined with "+
+				"dots, e.g. server.name or download.bitrate_bps", name)
+		}
+	}
+	return nil
+}
+
+// CheckCSVDelimiter returns an error if the delimiter can't separate the fields of the CSV format
+func CheckCSVDelimiter(delimiter string) error {
+	r := []rune(delimiter)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' || r[0] == utf8.RuneError {
+		return fmt.Errorf("invalid CSV delimiter %q, expected a single character other than a quote or line break", delimiter)
+	}
+	return nil
+}
+
+// newCSVFields returns the fields of the JSON report, nested fields named by their JSON names joined with dots, followed
+// by the rates in Mbps
+func newCSVFields() (map[string]csvField, []string) {
+	fields := make(map[string]csvField)
+	var names []string
+	addCSVFields(reflect.TypeOf(JSONReport{}), "", nil, fields, &names)
+
+	fields["download_mbps"] = func(rep JSONReport, opts Options) string {
+		return csvMbps(rep.Download.BitrateBps, opts)
+	}
+	fields["upload_mbps"] = func(rep JSONReport, opts Options) string {
+		return csvMbps(rep.Upload.BitrateBps, opts)
+	}
+	names = append(names, "download_mbps", "upload_mbps")
+	return fields, names
+}
+
+// addCSVFields adds the fields of struct type t, found at index in the JSON report, recursing into nested structs
+func addCSVFields(t reflect.Type, prefix string, index []int, fields map[string]csvField, names *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" || name == "schemaVersion" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		name = prefix + name
+		fieldIndex := append(append([]int{}, index...), i)
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft == reflect.TypeOf(time.Time{}):
+		case ft.Kind() == reflect.Struct:
+			addCSVFields(ft, name+".", fieldIndex, fields, names)
+			continue
+		case ft.Kind() == reflect.Slice || ft.Kind() == reflect.Map:
+			continue
+		}
+
+		fields[name] = func(rep JSONReport, _ Options) string {
+			return csvValue(reflect.ValueOf(rep), fieldIndex)
+		}
+		*names = append(*names, name)
+	}
+}
+
+// csvValue returns the field at index of v as a CSV value, empty if a pointer on the way is nil
+func csvValue(v reflect.Value, index []int) string {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return ""
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.String:
+		return v.String()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// csvMbps returns a rate in bit/s as Mbps, rounded to 2 decimals
+func csvMbps(bps float64, opts Options) string {
+	return strconv.FormatFloat(math.Round(units.Units{IEC: opts.UseMebi}.Mbps(bps)*100)/100, 'f', -1, 64)
+}
+
+// csvWriter writes a row for every result as soon as it's done, speeds in bit/s or Mbps and not affected by --bytes.
+// Fields containing the delimiter, quotes or line breaks are quoted
+type csvWriter struct {
+	w       *csv.Writer
+	opts    Options
+	columns []csvColumn
+}
+
+func newCSVWriter(w io.Writer, opts Options) Writer {
+	cw := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		cw.Comma = opts.Delimiter
+	}
+
+	columns := defaultCSVColumns
+	if len(opts.Fields) > 0 {
+		columns = make([]csvColumn, len(opts.Fields))
+		for i, field := range opts.Fields {
+			columns[i] = csvColumn{title: field, field: field}
+		}
+	}
+	return &csvWriter{w: cw, opts: opts, columns: columns}
+}
+
+// Begin implements Writer
+func (c *csvWriter) Begin() error {
+	if !c.opts.Header {
+		return nil
+	}
+	row := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		row[i] = col.title
+	}
+	return c.write(row)
+}
+
+// WriteResult implements Writer
+func (c *csvWriter) WriteResult(rep JSONReport) error {
+	row := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		field, ok := csvFields[col.field]
+		if !ok {
+			return fmt.Errorf("unknown CSV field %q", col.field)
+		}
+		row[i] = field(rep, c.opts)
+	}
+	return c.write(row)
+}
+
+// End implements Writer
+func (c *csvWriter) End() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// write writes a row, flushed so the rows can be read while the tests go on
+func (c *csvWriter) write(row []string) error {
+	if err := c.w.Write(row); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
 }
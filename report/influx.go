@@ -0,0 +1,63 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// influxMeasurement is the measurement of the results in the InfluxDB line protocol
+const influxMeasurement = "librespeed"
+
+func init() {
+	Register(FormatInflux, newInfluxWriter)
+}
+
+// influxWriter writes a line of InfluxDB line protocol for every result, with a nanosecond timestamp
+type influxWriter struct {
+	w io.Writer
+}
+
+func newInfluxWriter(w io.Writer, _ Options) Writer {
+	return &influxWriter{w: w}
+}
+
+// Begin implements Writer
+func (i *influxWriter) Begin() error {
+	return nil
+}
+
+// WriteResult implements Writer
+func (i *influxWriter) WriteResult(rep JSONReport) error {
+	_, err := io.WriteString(i.w, InfluxLine(rep)+"\n")
+	return err
+}
+
+// End implements Writer
+func (i *influxWriter) End() error {
+	return nil
+}
+
+// InfluxLine returns a result as a line of InfluxDB line protocol
+func InfluxLine(rep JSONReport) string {
+	var b strings.Builder
+	b.WriteString(escape(influxMeasurement, ", "))
+	for _, tag := range ResultTags(rep) {
+		fmt.Fprintf(&b, ",%s=%s", escape(tag.Key, ",= "), escape(tag.Value, ",= "))
+	}
+
+	for idx, field := range ResultFields(rep) {
+		sep := ","
+		if idx == 0 {
+			sep = " "
+		}
+		value := FormatValue(field)
+		if field.Integer {
+			value += "i"
+		}
+		fmt.Fprintf(&b, "%s%s=%s", sep, escape(field.Key, ",= "), value)
+	}
+
+	fmt.Fprintf(&b, " %d", rep.Timestamp.UnixNano())
+	return b.String()
+}
@@ -1,21 +1,64 @@
 package report
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"time"
 
 	"librespeed-cli/defs"
 )
 
-// JSONReport represents the output data fields in a JSON file
+// JSONReport represents the output data fields in a JSON file, versioned by SchemaVersion. Fields with a unit are
+// suffixed with it
 type JSONReport struct {
-	Timestamp time.Time                    `json:"timestamp"`
-	Server    Server                       `json:"server"`
-	Client    Client                       `json:"client"`
-	Ping      float64                      `json:"ping"`
-	Jitter    float64                      `json:"jitter"`
-	Upload    defs.TransferSummaryResponse `json:"upload"`
-	Download  defs.TransferSummaryResponse `json:"download"`
-	Share     string                       `json:"share"`
+	SchemaVersion     int       `json:"schemaVersion"`
+	Timestamp         time.Time `json:"timestamp"`
+	Server            Server    `json:"server"`
+	Client            Client    `json:"client"`
+	PingMs            float64   `json:"ping_ms"`
+	JitterMs          float64   `json:"jitter_ms"`
+	PacketLossPercent *float64  `json:"packet_loss_percent,omitempty"`
+	Upload            Transfer  `json:"upload"`
+	Download          Transfer  `json:"download"`
+	Share             string    `json:"share"`
+	Proxy             string    `json:"proxy,omitempty"`
+	Protocol          string    `json:"protocol"`
+	TLS               *TLS      `json:"tls,omitempty"`
+	Family            string    `json:"family,omitempty"`
+	DualStackWarning  string    `json:"dualStackWarning,omitempty"`
+	DataUsedBytes     int64     `json:"data_used_bytes"`
+}
+
+// Transfer represents the result of a download or upload test
+type Transfer struct {
+	BitrateBps float64                 `json:"bitrate_bps"`
+	Bytes      int64                   `json:"bytes"`
+	Packets    int                     `json:"packets"`
+	ElapsedMs  int64                   `json:"elapsed_ms"`
+	EndReason  string                  `json:"end_reason,omitempty"`
+	Interface  *defs.InterfaceCounters `json:"interface,omitempty"`
+}
+
+// NewTransfer returns the report of a download or upload test
+func NewTransfer(r defs.TransferSummaryResponse) Transfer {
+	t := Transfer{
+		Bytes:     int64(r.TotalBytes),
+		Packets:   r.TotalPackets,
+		ElapsedMs: r.Elapsed,
+		EndReason: r.EndReason,
+		Interface: r.Interface,
+	}
+	if r.Elapsed > 0 {
+		t.BitrateBps = float64(r.TotalBytes) * 8 / (float64(r.Elapsed) / 1000)
+	}
+	return t
+}
+
+// TLS represents the TLS version and cipher suite negotiated with the server
+type TLS struct {
+	Version string `json:"version"`
+	Cipher  string `json:"cipher"`
 }
 
 // Server represents the speed test server's information
@@ -23,11 +66,116 @@ type Server struct {
 	ID       int    `json:"id"`
 	Name     string `json:"name"`
 	URL      string `json:"url"`
+	IP       string `json:"ip"`
 	Location string `json:"location"`
 	Country  string `json:"country"`
+	Source   string `json:"source"`
 }
 
-// Client represents the speed test client's information
+// Client represents the speed test client's information, as seen by the server
 type Client struct {
-	defs.IPInfoResponse
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname"`
+	City     string `json:"city"`
+	Region   string `json:"region"`
+	Country  string `json:"country"`
+	Location string `json:"loc"`
+	Org      string `json:"org"`
+	Postal   string `json:"postal"`
+	Timezone string `json:"timezone"`
+}
+
+// NewClient returns the client information from the IP info of the server
+func NewClient(info defs.IPInfoResponse) Client {
+	return Client{
+		IP:       info.IP,
+		Hostname: info.Hostname,
+		City:     info.City,
+		Region:   info.Region,
+		Country:  info.Country,
+		Location: info.Location,
+		Org:      info.Organization,
+		Postal:   info.Postal,
+		Timezone: info.Timezone,
+	}
+}
+
+// JSONTestEnd is the JSONL event sent with the result of every test
+type JSONTestEnd struct {
+	defs.JSONEvent
+	Result JSONReport `json:"result"`
+}
+
+// JSONSummary is the JSONL event sent once all tests are done
+type JSONSummary struct {
+	defs.JSONEvent
+	Results       []JSONReport `json:"results"`
+	DataUsedBytes int64        `json:"data_used_bytes"`
+}
+
+func init() {
+	Register(FormatJSON, newJSONWriter)
+	Register(FormatJSONL, newJSONLWriter)
+}
+
+// jsonWriter writes the results as a JSON array once all tests are done
+type jsonWriter struct {
+	w    io.Writer
+	reps []JSONReport
+}
+
+func newJSONWriter(w io.Writer, _ Options) Writer {
+	return &jsonWriter{w: w, reps: []JSONReport{}}
+}
+
+// Begin implements Writer
+func (j *jsonWriter) Begin() error {
+	return nil
+}
+
+// WriteResult implements Writer
+func (j *jsonWriter) WriteResult(rep JSONReport) error {
+	j.reps = append(j.reps, rep)
+	return nil
+}
+
+// End implements Writer
+func (j *jsonWriter) End() error {
+	b, err := json.Marshal(&j.reps)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(j.w, "%s\n", b)
+	return err
+}
+
+// jsonlWriter writes the events of the run as they happen, a testEnd event for every result and a summary at the end
+type jsonlWriter struct {
+	w       io.Writer
+	summary JSONSummary
+}
+
+func newJSONLWriter(w io.Writer, _ Options) Writer {
+	return &jsonlWriter{w: w, summary: JSONSummary{Results: []JSONReport{}}}
+}
+
+// Begin implements Writer
+func (j *jsonlWriter) Begin() error {
+	defs.StartEvents(j.w)
+	return nil
+}
+
+// WriteResult implements Writer
+func (j *jsonlWriter) WriteResult(rep JSONReport) error {
+	j.summary.Results = append(j.summary.Results, rep)
+	j.summary.DataUsedBytes += rep.DataUsedBytes
+	defs.SendEvent("testEnd", &JSONTestEnd{Result: rep})
+	return nil
+}
+
+// End implements Writer
+func (j *jsonlWriter) End() error {
+	defs.SendEvent("summary", &j.summary)
+	defs.StopEvents()
+	return nil
 }
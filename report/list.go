@@ -0,0 +1,15 @@
+package report
+
+// ServerListEntry represents a server in the --list output. PingMs is only set for the servers pinged with --ping
+type ServerListEntry struct {
+	ID       int      `json:"id" csv:"ID"`
+	Name     string   `json:"name" csv:"Name"`
+	URL      string   `json:"url" csv:"URL"`
+	Location string   `json:"location" csv:"Location"`
+	Country  string   `json:"country" csv:"Country"`
+	Sponsor  string   `json:"sponsor" csv:"Sponsor"`
+	Source   string   `json:"source" csv:"Source"`
+	Status   string   `json:"status,omitempty" csv:"Status"`
+	PingMs   *float64 `json:"ping_ms,omitempty" csv:"Ping"`
+	Distance string   `json:"distance,omitempty" csv:"Distance"`
+}
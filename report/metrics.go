@@ -0,0 +1,75 @@
+package report
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Tag is a label identifying a result in the metric outputs
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Field is a value of a result in the metric outputs, its unit suffixed to the key and left out of the help text
+type Field struct {
+	Key     string
+	Value   float64
+	Integer bool
+	Help    string
+}
+
+// ResultTags returns the tags identifying a result: the server, the ISP, the IP version and the protocol. Tags with no
+// value are left out
+func ResultTags(rep JSONReport) []Tag {
+	var tags []Tag
+	add := func(key, value string) {
+		if value != "" {
+			tags = append(tags, Tag{Key: key, Value: value})
+		}
+	}
+
+	add("server_id", strconv.Itoa(rep.Server.ID))
+	add("server", rep.Server.Name)
+	add("isp", rep.Client.Org)
+	add("family", rep.Family)
+	add("protocol", rep.Protocol)
+	return tags
+}
+
+// ResultFields returns the measurements of a result
+func ResultFields(rep JSONReport) []Field {
+	return []Field{
+		{Key: "ping_ms", Value: rep.PingMs, Help: "Ping to the server"},
+		{Key: "jitter_ms", Value: rep.JitterMs, Help: "Jitter of the ping to the server"},
+		{Key: "download_bps", Value: rep.Download.BitrateBps, Help: "Download rate"},
+		{Key: "upload_bps", Value: rep.Upload.BitrateBps, Help: "Upload rate"},
+		{Key: "download_bytes", Value: float64(rep.Download.Bytes), Integer: true, Help: "Bytes received by the download test"},
+		{Key: "upload_bytes", Value: float64(rep.Upload.Bytes), Integer: true, Help: "Bytes sent by the upload test"},
+		{Key: "data_used_bytes", Value: float64(rep.DataUsedBytes), Integer: true, Help: "Bytes used by the download and upload tests"},
+	}
+}
+
+// FormatValue returns the value of a field as text, without exponent for integers
+func FormatValue(f Field) string {
+	if f.Integer {
+		return strconv.FormatInt(int64(f.Value), 10)
+	}
+	return strconv.FormatFloat(f.Value, 'f', -1, 64)
+}
+
+// escape backslash-escapes the characters given in s
+func escape(s, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
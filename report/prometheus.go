@@ -0,0 +1,88 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// promPrefix is the prefix of the Prometheus metric names
+const promPrefix = "librespeed_"
+
+func init() {
+	Register(FormatProm, newPromWriter)
+}
+
+// promWriter writes the results as metrics in the Prometheus text format, for the textfile collector of node_exporter.
+// The samples of each metric are grouped, so they are written once all tests are done
+type promWriter struct {
+	w    io.Writer
+	reps []JSONReport
+}
+
+func newPromWriter(w io.Writer, _ Options) Writer {
+	return &promWriter{w: w}
+}
+
+// Begin implements Writer
+func (p *promWriter) Begin() error {
+	return nil
+}
+
+// WriteResult implements Writer
+func (p *promWriter) WriteResult(rep JSONReport) error {
+	p.reps = append(p.reps, rep)
+	return nil
+}
+
+// End implements Writer
+func (p *promWriter) End() error {
+	var b strings.Builder
+
+	if len(p.reps) > 0 {
+		for i, field := range ResultFields(p.reps[0]) {
+			name, _ := promMetric(field)
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, field.Help, name)
+			for _, rep := range p.reps {
+				f := ResultFields(rep)[i]
+				_, value := promMetric(f)
+				fmt.Fprintf(&b, "%s%s %s\n", name, promLabels(ResultTags(rep)), FormatValue(value))
+			}
+		}
+	}
+
+	name := promPrefix + "last_run_timestamp_seconds"
+	fmt.Fprintf(&b, "# HELP %s Time the speed test run ended\n# TYPE %s gauge\n", name, name)
+	fmt.Fprintf(&b, "%s %d\n", name, time.Now().Unix())
+
+	_, err := io.WriteString(p.w, b.String())
+	return err
+}
+
+// promMetric returns the name of the Prometheus metric of a field, and the field in the base unit of Prometheus
+func promMetric(f Field) (string, Field) {
+	switch {
+	case strings.HasSuffix(f.Key, "_ms"):
+		f.Value /= 1000
+		return promPrefix + strings.TrimSuffix(f.Key, "_ms") + "_seconds", f
+	case strings.HasSuffix(f.Key, "_bps"):
+		return promPrefix + strings.TrimSuffix(f.Key, "_bps") + "_bits_per_second", f
+	default:
+		return promPrefix + f.Key, f
+	}
+}
+
+// promLabels returns the tags as the label set of a sample
+func promLabels(tags []Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	labels := make([]string, len(tags))
+	for i, tag := range tags {
+		value := strings.ReplaceAll(escape(tag.Value, `\"`), "\n", `\n`)
+		labels[i] = fmt.Sprintf(`%s="%s"`, tag.Key, value)
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
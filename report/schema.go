@@ -0,0 +1,272 @@
+package report
+
+//go:generate go run ./schemagen -dir ../schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"librespeed-cli/defs"
+)
+
+// schemaDialect is the JSON Schema version of the generated schemas
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema document
+type Schema map[string]interface{}
+
+// Schemas returns the JSON Schemas of the JSON report and of each JSONL event, by file name
+func Schemas() map[string]Schema {
+	result := SchemaOf(reflect.TypeOf(JSONReport{}))
+	result["properties"].(Schema)["schemaVersion"] = Schema{"const": defs.SchemaVersion}
+	ret := map[string]Schema{
+		"report.schema.json": document("LibreSpeed CLI report", Schema{
+			"type":  "array",
+			"items": result,
+		}),
+	}
+
+	for name, v := range events() {
+		s := SchemaOf(reflect.TypeOf(v))
+		s["properties"].(Schema)["schemaVersion"] = Schema{"const": defs.SchemaVersion}
+		s["properties"].(Schema)["type"] = Schema{"const": name}
+		ret["event-"+name+".schema.json"] = document("LibreSpeed CLI "+name+" event", s)
+	}
+
+	return ret
+}
+
+// events returns a value of each JSONL event type, by the name in its `type` field
+func events() map[string]interface{} {
+	return map[string]interface{}{
+		"serverSelectionStart":  defs.JSONProgressServerSelectionStart{},
+		"serverSelection":       defs.JSONProgressServerSelection{},
+		"serverSelectionResult": defs.JSONProgressServerSelectionResult{},
+		"testStart":             defs.JSONProgressHeader{},
+		"ping":                  defs.JSONProgressPing{},
+		"download":              defs.JSONProgressDownload{},
+		"upload":                defs.JSONProgressUpload{},
+		"testEnd":               JSONTestEnd{},
+		"error":                 defs.JSONError{},
+		"summary":               JSONSummary{},
+	}
+}
+
+// EventSchema returns the file name of the schema of a JSONL event type
+func EventSchema(name string) (string, bool) {
+	if _, ok := events()[name]; !ok {
+		return "", false
+	}
+	return "event-" + name + ".schema.json", true
+}
+
+// document adds the dialect and title to the schema of a document
+func document(title string, s Schema) Schema {
+	s["$schema"] = schemaDialect
+	s["title"] = title
+	return s
+}
+
+// SchemaOf returns the JSON Schema of the values of type t as encoded by encoding/json. Struct fields are required
+// unless tagged omitempty, and no other properties are allowed
+func SchemaOf(t reflect.Type) Schema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return Schema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return SchemaOf(t.Elem())
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": []interface{}{"array", "null"}, "items": SchemaOf(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": []interface{}{"object", "null"}, "additionalProperties": SchemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := Schema{}
+		var required []string
+		addFields(t, properties, &required)
+		sort.Strings(required)
+		s := Schema{"type": "object", "properties": properties, "additionalProperties": false}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	default:
+		return Schema{}
+	}
+}
+
+// addFields adds the properties of the fields of struct type t, including the fields of embedded structs
+func addFields(t reflect.Type, properties Schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addFields(f.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		properties[name] = SchemaOf(f.Type)
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// Validate checks a JSON value decoded by encoding/json against the subset of JSON Schema used by SchemaOf, and
+// returns the first mismatch found
+func (s Schema) Validate(v interface{}) error {
+	return validate(s, v, "$")
+}
+
+func validate(s Schema, v interface{}, path string) error {
+	if c, ok := s["const"]; ok {
+		// compare the encoded values, as numbers of generated schemas aren't decoded as float64
+		want, _ := json.Marshal(c)
+		got, _ := json.Marshal(v)
+		if !bytes.Equal(want, got) {
+			return fmt.Errorf("%s: expected %s, got %s", path, want, got)
+		}
+	}
+
+	if t, ok := s["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []interface{}:
+			for _, name := range t {
+				types = append(types, fmt.Sprint(name))
+			}
+		}
+
+		var matched bool
+		for _, name := range types {
+			if hasType(v, name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %T", path, strings.Join(types, " or "), v)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		properties, _ := asSchema(s["properties"])
+		for _, name := range requiredOf(s) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing property %q", path, name)
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if p, ok := asSchema(properties[name]); ok {
+				if err := validate(p, v[name], path+"."+name); err != nil {
+					return err
+				}
+				continue
+			}
+			if extra, ok := s["additionalProperties"].(bool); ok && !extra {
+				return fmt.Errorf("%s: unexpected property %q", path, name)
+			}
+			if extra, ok := asSchema(s["additionalProperties"]); ok {
+				if err := validate(extra, v[name], path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if items, ok := asSchema(s["items"]); ok {
+			for i, item := range v {
+				if err := validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// asSchema returns a schema generated by SchemaOf or decoded from a file
+func asSchema(v interface{}) (Schema, bool) {
+	switch v := v.(type) {
+	case Schema:
+		return v, true
+	case map[string]interface{}:
+		return v, true
+	}
+	return nil, false
+}
+
+// requiredOf returns the required properties of an object schema
+func requiredOf(s Schema) []string {
+	switch r := s["required"].(type) {
+	case []string:
+		return r
+	case []interface{}:
+		var ret []string
+		for _, name := range r {
+			ret = append(ret, fmt.Sprint(name))
+		}
+		return ret
+	}
+	return nil
+}
+
+// hasType tells whether a decoded JSON value is of the JSON Schema type
+func hasType(v interface{}, name string) bool {
+	switch name {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"librespeed-cli/defs"
+)
+
+// schemaDir is the directory of the committed schema files, written by go generate
+const schemaDir = "../schema"
+
+func TestSchemasUpToDate(t *testing.T) {
+	schemas := Schemas()
+	for name, s := range schemas {
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		committed, err := os.ReadFile(filepath.Join(schemaDir, name))
+		if err != nil {
+			t.Errorf("%s: %s, run go generate ./report", name, err)
+			continue
+		}
+		if !bytes.Equal(append(b, '\n'), committed) {
+			t.Errorf("%s is out of date, run go generate ./report", name)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(schemaDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if _, ok := schemas[filepath.Base(file)]; !ok {
+			t.Errorf("%s isn't generated anymore, remove it", file)
+		}
+	}
+}
+
+func TestReportMatchesSchema(t *testing.T) {
+	s := loadSchema(t, "report.schema.json")
+
+	var filled JSONReport
+	fill(reflect.ValueOf(&filled).Elem())
+	filled.SchemaVersion = defs.SchemaVersion
+	empty := JSONReport{SchemaVersion: defs.SchemaVersion}
+
+	for name, rep := range map[string]JSONReport{"filled": filled, "empty": empty} {
+		if err := s.Validate(roundTrip(t, []JSONReport{rep})); err != nil {
+			t.Errorf("%s report: %s", name, err)
+		}
+	}
+}
+
+func TestEventsMatchSchemas(t *testing.T) {
+	names := make([]string, 0, len(events()))
+	for name := range events() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			file, ok := EventSchema(name)
+			if !ok {
+				t.Fatalf("no schema for event %s", name)
+			}
+			s := loadSchema(t, file)
+
+			v := reflect.New(reflect.TypeOf(events()[name])).Elem()
+			fill(v)
+			v.FieldByName("SchemaVersion").SetInt(defs.SchemaVersion)
+			v.FieldByName("Type").SetString(name)
+
+			if err := s.Validate(roundTrip(t, v.Interface())); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSchemaRejectsMismatch(t *testing.T) {
+	s := loadSchema(t, "event-ping.schema.json")
+
+	ev := defs.JSONProgressPing{}
+	ev.SchemaVersion = defs.SchemaVersion
+	ev.Type = "download"
+	if err := s.Validate(roundTrip(t, ev)); err == nil {
+		t.Error("ping schema accepted an event of another type")
+	}
+
+	ev.Type = "ping"
+	v := roundTrip(t, ev).(map[string]interface{})
+	v["unknown"] = true
+	if err := s.Validate(v); err == nil {
+		t.Error("ping schema accepted an unknown property")
+	}
+}
+
+// loadSchema reads a committed schema file
+func loadSchema(t *testing.T, name string) Schema {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(schemaDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Schema
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	return s
+}
+
+// roundTrip encodes the value as JSON and decodes it, as read by a consumer of the output
+func roundTrip(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ret interface{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+// fill sets every exported field reachable from v to a non-zero value, so fields tagged omitempty are encoded too
+func fill(v reflect.Value) {
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		v.Set(reflect.ValueOf(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem())
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key := reflect.New(v.Type().Key()).Elem()
+		fill(key)
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fill(elem)
+		v.SetMapIndex(key, elem)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fill(v.Field(i))
+			}
+		}
+	}
+}
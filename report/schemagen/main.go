@@ -0,0 +1,116 @@
+// Command schemagen writes the JSON Schemas of the JSON report and JSONL events, generated from the Go structs, and
+// validates output of librespeed-cli against them
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"librespeed-cli/report"
+)
+
+func main() {
+	dir := flag.String("dir", "schema", "directory of the schema files")
+	check := flag.Bool("validate", false, "validate the JSON report or JSONL events in the files given, or stdin,\n"+
+		"against the schema files instead of writing them")
+	flag.Parse()
+
+	var err error
+	if *check {
+		err = validate(*dir, flag.Args())
+	} else {
+		err = generate(*dir)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// generate writes the schema files to dir
+func generate(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	schemas := report.Schemas()
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		b, err := json.MarshalIndent(schemas[name], "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), append(b, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks every JSON value in the files against the schema files in dir: arrays against the report schema,
+// and objects against the schema of the event in their `type` field
+func validate(dir string, files []string) error {
+	if len(files) == 0 {
+		return validateReader(dir, "stdin", os.Stdin)
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = validateReader(dir, file, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateReader(dir, name string, r io.Reader) error {
+	schemas := make(map[string]report.Schema)
+	dec := json.NewDecoder(r)
+	for i := 1; ; i++ {
+		var v interface{}
+		if err := dec.Decode(&v); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		file := "report.schema.json"
+		if obj, ok := v.(map[string]interface{}); ok {
+			eventType, _ := obj["type"].(string)
+			if file, ok = report.EventSchema(eventType); !ok {
+				return fmt.Errorf("%s: value %d: unknown event type %q", name, i, eventType)
+			}
+		}
+
+		schema, ok := schemas[file]
+		if !ok {
+			b, err := os.ReadFile(filepath.Join(dir, file))
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(b, &schema); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			schemas[file] = schema
+		}
+
+		if err := schema.Validate(v); err != nil {
+			return fmt.Errorf("%s: value %d does not match %s: %w", name, i, file, err)
+		}
+	}
+}
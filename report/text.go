@@ -0,0 +1,127 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"librespeed-cli/units"
+)
+
+func init() {
+	Register(FormatText, newTextWriter)
+	Register(FormatSimple, func(w io.Writer, opts Options) Writer {
+		return &textWriter{w: w, opts: opts, simple: true}
+	})
+}
+
+// textWriter writes the results for humans. The text format is a table of the results once all tests are done, the
+// simple format the ping and rates of each result
+type textWriter struct {
+	w      io.Writer
+	opts   Options
+	simple bool
+	reps   []JSONReport
+}
+
+func newTextWriter(w io.Writer, opts Options) Writer {
+	return &textWriter{w: w, opts: opts}
+}
+
+// Begin implements Writer
+func (t *textWriter) Begin() error {
+	return nil
+}
+
+// WriteResult implements Writer
+func (t *textWriter) WriteResult(rep JSONReport) error {
+	if !t.simple {
+		t.reps = append(t.reps, rep)
+		return nil
+	}
+
+	u := t.units()
+	_, err := fmt.Fprintf(t.w, "Ping:\t%.0f ms\tJitter:\t%.0f ms\nDownload rate:\t%s\nUpload rate:\t%s\n",
+		rep.PingMs, rep.JitterMs, u.Rate(rep.Download.BitrateBps), u.Rate(rep.Upload.BitrateBps))
+	if err != nil {
+		return err
+	}
+	return t.writeShare(rep, false)
+}
+
+// End implements Writer
+func (t *textWriter) End() error {
+	if len(t.reps) == 0 {
+		return nil
+	}
+
+	if err := WriteTable(t.w, t.reps, t.units()); err != nil {
+		return err
+	}
+	for _, rep := range t.reps {
+		if err := t.writeShare(rep, len(t.reps) > 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTable writes the results as a table, a row per result
+func WriteTable(w io.Writer, reps []JSONReport, u units.Units) error {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Ping\tJitter\tLoss\tDownload\tUpload\tData used\tServer\tISP")
+	for _, rep := range reps {
+		loss := "-"
+		if rep.PacketLossPercent != nil {
+			loss = fmt.Sprintf("%.0f%%", *rep.PacketLossPercent)
+		}
+		server := rep.Server.Name
+		if rep.Family != "" {
+			server += " (" + rep.Family + ")"
+		}
+		fmt.Fprintf(tw, "%.0f ms\t%.0f ms\t%s\t%s\t%s\t%s\t%s\t%s\n", rep.PingMs, rep.JitterMs, loss,
+			transferRate(u, rep.Download), transferRate(u, rep.Upload), u.Size(rep.DataUsedBytes), server, orDash(rep.Client.Org))
+	}
+	tw.Flush()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeShare writes the share link of a result if any, naming the server if there are several results
+func (t *textWriter) writeShare(rep JSONReport, named bool) error {
+	if rep.Share == "" {
+		return nil
+	}
+
+	var err error
+	if named {
+		_, err = fmt.Fprintf(t.w, "Share your result on %s: %s\n", rep.Server.Name, rep.Share)
+	} else {
+		_, err = fmt.Fprintf(t.w, "Share your result: %s\n", rep.Share)
+	}
+	return err
+}
+
+// units returns the units of the rates and sizes given by the options
+func (t *textWriter) units() units.Units {
+	return units.Units{Bytes: t.opts.UseBytes, IEC: t.opts.UseMebi}
+}
+
+// transferRate returns the rate of a transfer, or a dash if the test didn't run
+func transferRate(u units.Units, tr Transfer) string {
+	if tr.ElapsedMs == 0 {
+		return "-"
+	}
+	return u.Rate(tr.BitrateBps)
+}
+
+// orDash returns the string, or a dash if empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
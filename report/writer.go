@@ -0,0 +1,117 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// the output formats built in
+const (
+	FormatText   = "text"
+	FormatSimple = "simple"
+	FormatJSON   = "json"
+	FormatJSONL  = "jsonl"
+	FormatCSV    = "csv"
+	FormatTSV    = "tsv"
+	FormatProm   = "prom-textfile"
+	FormatInflux = "influx"
+)
+
+// Writer writes the results of a speed test run in an output format
+type Writer interface {
+	// Begin is called once before the first result
+	Begin() error
+	// WriteResult is called with the result of every test
+	WriteResult(rep JSONReport) error
+	// End is called once after the last result
+	End() error
+}
+
+// Options are the settings shared by the output formats
+type Options struct {
+	// UseBytes and UseMebi show the rates of human readable formats in bytes, and the sizes with IEC prefixes
+	UseBytes bool
+	UseMebi  bool
+	// Delimiter separates the fields of the CSV format
+	Delimiter rune
+	// Header writes the CSV header before the first result
+	Header bool
+	// Fields are the columns of the CSV format, the default columns if empty
+	Fields []string
+}
+
+// NewWriterFunc creates the writer of an output format, writing to `w`
+type NewWriterFunc func(w io.Writer, opts Options) Writer
+
+var formats = make(map[string]NewWriterFunc)
+
+// Register adds an output format, replacing any format registered with the same name
+func Register(name string, fn NewWriterFunc) {
+	formats[name] = fn
+}
+
+// Formats returns the names of the registered output formats, sorted
+func Formats() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckFormat returns an error if the output format isn't registered
+func CheckFormat(name string) error {
+	if _, ok := formats[name]; !ok {
+		return fmt.Errorf("unknown output format %q, supported formats are %s", name, strings.Join(Formats(), ", "))
+	}
+	return nil
+}
+
+// NewWriter returns a writer for the named output format
+func NewWriter(name string, w io.Writer, opts Options) (Writer, error) {
+	if err := CheckFormat(name); err != nil {
+		return nil, err
+	}
+	return formats[name](w, opts), nil
+}
+
+// multiWriter passes the results to all its writers
+type multiWriter []Writer
+
+// MultiWriter returns a writer passing the results to all the writers given, stopping at the first error
+func MultiWriter(writers ...Writer) Writer {
+	return multiWriter(writers)
+}
+
+// Begin implements Writer
+func (m multiWriter) Begin() error {
+	for _, w := range m {
+		if err := w.Begin(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteResult implements Writer
+func (m multiWriter) WriteResult(rep JSONReport) error {
+	for _, w := range m {
+		if err := w.WriteResult(rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// End implements Writer
+func (m multiWriter) End() error {
+	for _, w := range m {
+		if err := w.End(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
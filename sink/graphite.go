@@ -0,0 +1,87 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"librespeed-cli/report"
+)
+
+func init() {
+	Register("graphite", newGraphite)
+}
+
+// graphiteSink sends the results to Graphite with the plaintext protocol, as tagged series like
+// librespeed.ping_ms;server_id=1;server=Amsterdam 12.5 1700000000
+type graphiteSink struct {
+	dial   dialFunc
+	addr   string
+	prefix string
+}
+
+// newGraphite returns a sink given as graphite://host:2003?prefix=PREFIX
+func newGraphite(u *url.URL, opts Options) (Sink, error) {
+	prefix := u.Query().Get("prefix")
+	if prefix == "" {
+		prefix = "librespeed"
+	}
+	return &graphiteSink{dial: opts.dial(), addr: hostPort(u, "2003"), prefix: prefix}, nil
+}
+
+// Encode implements Sink
+func (g *graphiteSink) Encode(reps []report.JSONReport) ([]byte, error) {
+	var b strings.Builder
+	for _, rep := range reps {
+		var tags string
+		for _, tag := range report.ResultTags(rep) {
+			tags += ";" + tag.Key + "=" + graphiteTag(tag.Value)
+		}
+		for _, field := range report.ResultFields(rep) {
+			fmt.Fprintf(&b, "%s.%s%s %s %d\n", g.prefix, field.Key, tags, report.FormatValue(field), rep.Timestamp.Unix())
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// Send implements Sink
+func (g *graphiteSink) Send(ctx context.Context, payload []byte) error {
+	return sendStream(ctx, g.dial, "tcp", g.addr, payload)
+}
+
+// graphiteTag replaces the characters not allowed in the tag values of the plaintext protocol
+func graphiteTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ';' || r == '~' || r == ' ' || r == '\t' || r == '\n' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// hostPort returns the address of a sink URL, with the default port if none given
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// sendStream connects to the address with dial and writes the payload within the deadline of ctx
+func sendStream(ctx context.Context, dial dialFunc, network, addr string, payload []byte) error {
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return err
+	}
+	return conn.Close()
+}
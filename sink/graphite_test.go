@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"librespeed-cli/report"
+)
+
+func TestGraphite(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		b, _ := io.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	// the connection goes through the dial function of the options
+	var dialed string
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = network + " " + address
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+	s, err := New("graphite://"+l.Addr().String()+"?prefix=home.speed", Options{Dial: dial})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := s.Encode([]report.JSONReport{testReport()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.Send(ctx, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "tcp " + l.Addr().String(); dialed != want {
+		t.Errorf("dialed %q, want %q", dialed, want)
+	}
+	lines := strings.Split(strings.TrimSuffix(<-received, "\n"), "\n")
+	want := []string{
+		"home.speed.ping_ms;server_id=3;server=Den_Lab_1;isp=ISP,_Inc 12.5 1714979289",
+		"home.speed.jitter_ms;server_id=3;server=Den_Lab_1;isp=ISP,_Inc 0.25 1714979289",
+		"home.speed.download_bps;server_id=3;server=Den_Lab_1;isp=ISP,_Inc 93456789 1714979289",
+		"home.speed.upload_bps;server_id=3;server=Den_Lab_1;isp=ISP,_Inc 41000000 1714979289",
+		"home.speed.download_bytes;server_id=3;server=Den_Lab_1;isp=ISP,_Inc 1000 1714979289",
+		"home.speed.upload_bytes;server_id=3;server=Den_Lab_1;isp=ISP,_Inc 500 1714979289",
+		"home.speed.data_used_bytes;server_id=3;server=Den_Lab_1;isp=ISP,_Inc 1500 1714979289",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("received:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	for _, tt := range []struct{ url, want string }{
+		{"graphite://graphite.lan", "graphite.lan:2003"},
+		{"graphite://graphite.lan:2013", "graphite.lan:2013"},
+		{"graphite://[::1]", "[::1]:2003"},
+	} {
+		s, err := New(tt.url, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr := s.(*graphiteSink).addr; addr != tt.want {
+			t.Errorf("%s: address %s, want %s", tt.url, addr, tt.want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"librespeed-cli/defs"
+	"librespeed-cli/report"
+)
+
+const (
+	// haOnline and haOffline are the payloads of the availability topic
+	haOnline  = "online"
+	haOffline = "offline"
+)
+
+func init() {
+	Register("homeassistant", newHomeAssistant)
+	Register("homeassistant+mqtts", newHomeAssistant)
+}
+
+// haSensor is a sensor announced to Home Assistant, reading the value of its key in the state
+type haSensor struct {
+	key         string
+	name        string
+	unit        string
+	deviceClass string
+	stateClass  string
+	icon        string
+}
+
+// haSensors are the sensors of the device of a host
+var haSensors = []haSensor{
+	{key: "ping", name: "Ping", unit: "ms", deviceClass: "duration", stateClass: "measurement"},
+	{key: "jitter", name: "Jitter", unit: "ms", deviceClass: "duration", stateClass: "measurement"},
+	{key: "download", name: "Download", unit: "Mbit/s", deviceClass: "data_rate", stateClass: "measurement"},
+	{key: "upload", name: "Upload", unit: "Mbit/s", deviceClass: "data_rate", stateClass: "measurement"},
+	{key: "server", name: "Server", icon: "mdi:server-network"},
+	{key: "last_run", name: "Last run", deviceClass: "timestamp"},
+}
+
+// haState is the state of the sensors, published as JSON to the state topic
+type haState struct {
+	Ping     float64 `json:"ping"`
+	Jitter   float64 `json:"jitter"`
+	Download float64 `json:"download"`
+	Upload   float64 `json:"upload"`
+	Server   string  `json:"server"`
+	LastRun  string  `json:"last_run"`
+}
+
+// haConfig is the MQTT discovery config of a sensor
+type haConfig struct {
+	Name                string   `json:"name"`
+	UniqueID            string   `json:"unique_id"`
+	StateTopic          string   `json:"state_topic"`
+	ValueTemplate       string   `json:"value_template"`
+	Unit                string   `json:"unit_of_measurement,omitempty"`
+	DeviceClass         string   `json:"device_class,omitempty"`
+	StateClass          string   `json:"state_class,omitempty"`
+	Icon                string   `json:"icon,omitempty"`
+	AvailabilityTopic   string   `json:"availability_topic"`
+	PayloadAvailable    string   `json:"payload_available"`
+	PayloadNotAvailable string   `json:"payload_not_available"`
+	Device              haDevice `json:"device"`
+}
+
+// haDevice is the device grouping the sensors of a host
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// haMessage is a message published to the broker, as spooled
+type haMessage struct {
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+}
+
+// homeAssistantSink announces the sensors of the host with MQTT discovery and publishes their state, given as
+// homeassistant://[user:password@]host:1883/TOPIC_PREFIX?discovery_prefix=homeassistant&node_id=NODE_ID. The host is
+// online from the start of the run, and offline if the run is interrupted before the results are sent
+type homeAssistantSink struct {
+	cfg             mqttConfig
+	discoveryPrefix string
+	prefix          string
+	nodeID          string
+	client          *mqttClient
+}
+
+// newHomeAssistant returns a Home Assistant sink. The node ID defaults to the host name, and the topic prefix to
+// librespeed/NODE_ID
+func newHomeAssistant(u *url.URL, opts Options) (Sink, error) {
+	q := u.Query()
+	nodeID := q.Get("node_id")
+	if nodeID == "" {
+		host, _ := os.Hostname()
+		nodeID = "librespeed_" + host
+	}
+	nodeID = haID(nodeID)
+
+	prefix := strings.Trim(u.Path, "/")
+	if prefix == "" {
+		prefix = "librespeed/" + nodeID
+	}
+	discoveryPrefix := q.Get("discovery_prefix")
+	if discoveryPrefix == "" {
+		discoveryPrefix = "homeassistant"
+	}
+
+	return &homeAssistantSink{cfg: newMQTTConfig(u, opts), discoveryPrefix: discoveryPrefix, prefix: prefix, nodeID: nodeID}, nil
+}
+
+// Start implements Starter, announcing the sensors and the host online
+func (h *homeAssistantSink) Start(ctx context.Context) error {
+	return h.publish(ctx, h.announce())
+}
+
+// Encode implements Sink. The sensors are announced again, so spooled results are complete if the broker lost the
+// retained messages. With several results, the state is the last one
+func (h *homeAssistantSink) Encode(reps []report.JSONReport) ([]byte, error) {
+	rep := reps[len(reps)-1]
+	state, err := json.Marshal(haState{
+		Ping:     round(rep.PingMs),
+		Jitter:   round(rep.JitterMs),
+		Download: round(rep.Download.BitrateBps / 1e6),
+		Upload:   round(rep.Upload.BitrateBps / 1e6),
+		Server:   rep.Server.Name,
+		LastRun:  rep.Timestamp.Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(append(h.announce(), haMessage{Topic: h.prefix + "/state", Payload: string(state)}))
+}
+
+// Send implements Sink, over the connection of Start if still open
+func (h *homeAssistantSink) Send(ctx context.Context, payload []byte) error {
+	var messages []haMessage
+	if err := json.Unmarshal(payload, &messages); err != nil {
+		return err
+	}
+	return h.publish(ctx, messages)
+}
+
+// Close implements io.Closer, disconnecting so the host stays online until the next run
+func (h *homeAssistantSink) Close() error {
+	if h.client == nil {
+		return nil
+	}
+	err := h.client.Disconnect()
+	h.client = nil
+	return err
+}
+
+// publish publishes retained messages, connecting with the offline will if not connected. The connection is dropped
+// on error, to reconnect on the next attempt
+func (h *homeAssistantSink) publish(ctx context.Context, messages []haMessage) error {
+	if h.client == nil {
+		will := &mqttMessage{topic: h.prefix + "/availability", payload: []byte(haOffline), qos: 1, retain: true}
+		client, err := dialMQTT(ctx, h.cfg, will)
+		if err != nil {
+			return err
+		}
+		h.client = client
+	}
+
+	for _, msg := range messages {
+		if err := h.client.Publish(ctx, mqttMessage{topic: msg.Topic, payload: []byte(msg.Payload), qos: 1, retain: true}); err != nil {
+			h.client.Close()
+			h.client = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// announce returns the discovery configs of the sensors, followed by the host online
+func (h *homeAssistantSink) announce() []haMessage {
+	device := haDevice{
+		Identifiers:  []string{h.nodeID},
+		Name:         "LibreSpeed " + strings.TrimPrefix(h.nodeID, "librespeed_"),
+		Manufacturer: "LibreSpeed",
+		Model:        "librespeed-cli",
+		SWVersion:    defs.ProgVersion,
+	}
+
+	var messages []haMessage
+	for _, s := range haSensors {
+		config, _ := json.Marshal(haConfig{
+			Name:                s.name,
+			UniqueID:            h.nodeID + "_" + s.key,
+			StateTopic:          h.prefix + "/state",
+			ValueTemplate:       "{{ value_json." + s.key + " }}",
+			Unit:                s.unit,
+			DeviceClass:         s.deviceClass,
+			StateClass:          s.stateClass,
+			Icon:                s.icon,
+			AvailabilityTopic:   h.prefix + "/availability",
+			PayloadAvailable:    haOnline,
+			PayloadNotAvailable: haOffline,
+			Device:              device,
+		})
+		topic := h.discoveryPrefix + "/sensor/" + h.nodeID + "/" + s.key + "/config"
+		messages = append(messages, haMessage{Topic: topic, Payload: string(config)})
+	}
+	return append(messages, haMessage{Topic: h.prefix + "/availability", Payload: haOnline})
+}
+
+// haID replaces the characters not allowed in the node IDs of MQTT discovery
+func haID(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// round rounds a value to 2 decimals
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,266 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"librespeed-cli/report"
+)
+
+// brokerSession is what the test broker recorded of a client connection
+type brokerSession struct {
+	clientID     string
+	will         *mqttMessage
+	publishes    []mqttMessage
+	disconnected bool
+	err          error
+}
+
+// startBroker starts a MQTT broker stand-in accepting CONNECT and acknowledging PUBLISH, and returns its address and
+// the sessions recorded once their connection ends
+func startBroker(t *testing.T) (string, <-chan *brokerSession) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	sessions := make(chan *brokerSession, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s := &brokerSession{}
+				s.err = serveBroker(conn, s)
+				sessions <- s
+			}()
+		}
+	}()
+	return l.Addr().String(), sessions
+}
+
+// serveBroker records the packets of a connection until it ends
+func serveBroker(conn net.Conn, s *brokerSession) error {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	w := &mqttClient{conn: conn}
+
+	for {
+		header, body, err := readTestPacket(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch header >> 4 {
+		case mqttConnect:
+			if err := parseConnect(body, s); err != nil {
+				return err
+			}
+			if err := w.writePacket(context.Background(), mqttConnAck<<4, []byte{0, 0}); err != nil {
+				return err
+			}
+		case mqttPublish:
+			msg := mqttMessage{qos: header >> 1 & 0x03, retain: header&0x01 != 0}
+			topic, rest := readTestString(body)
+			msg.topic = topic
+			if msg.qos > 0 {
+				if err := w.writePacket(context.Background(), mqttPubAck<<4, rest[:2]); err != nil {
+					return err
+				}
+				rest = rest[2:]
+			}
+			msg.payload = rest
+			s.publishes = append(s.publishes, msg)
+		case mqttDisconnect:
+			s.disconnected = true
+		}
+	}
+}
+
+// parseConnect records the client ID and will of a CONNECT
+func parseConnect(body []byte, s *brokerSession) error {
+	name, rest := readTestString(body)
+	if name != "MQTT" || len(rest) < 4 || rest[0] != 4 {
+		return errors.New("unexpected CONNECT protocol")
+	}
+	flags := rest[1]
+	s.clientID, rest = readTestString(rest[4:])
+	if flags&0x04 != 0 {
+		s.will = &mqttMessage{qos: flags >> 3 & 0x03, retain: flags&0x20 != 0}
+		s.will.topic, rest = readTestString(rest)
+		payload, _ := readTestString(rest)
+		s.will.payload = []byte(payload)
+	}
+	return nil
+}
+
+// readTestPacket reads a control packet, returning its fixed header byte and body
+func readTestPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, shift int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+// readTestString reads a length prefixed string, returning it and the rest of b
+func readTestString(b []byte) (string, []byte) {
+	n := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+n]), b[2+n:]
+}
+
+func newTestHomeAssistant(t *testing.T, addr string) *homeAssistantSink {
+	t.Helper()
+	s, err := New("homeassistant://"+addr+"?node_id=node1&client_id=test", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*homeAssistantSink)
+}
+
+func waitSession(t *testing.T, sessions <-chan *brokerSession) *brokerSession {
+	t.Helper()
+	select {
+	case s := <-sessions:
+		if s.err != nil {
+			t.Fatalf("broker: %s", s.err)
+		}
+		return s
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the connection to end")
+	}
+	return nil
+}
+
+func TestHomeAssistant(t *testing.T) {
+	addr, sessions := startBroker(t)
+	h := newTestHomeAssistant(t, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := h.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var rep report.JSONReport
+	rep.PingMs = 12.345
+	rep.JitterMs = 1.5
+	rep.Download.BitrateBps = 93456789
+	rep.Upload.BitrateBps = 41000000
+	rep.Server.Name = "Test server"
+	rep.Timestamp = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	payload, err := h.Encode([]report.JSONReport{rep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Send(ctx, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := waitSession(t, sessions)
+	if s.clientID != "test" {
+		t.Errorf("client ID %q, want test", s.clientID)
+	}
+	if s.will == nil || s.will.topic != "librespeed/node1/availability" || string(s.will.payload) != haOffline ||
+		!s.will.retain || s.will.qos != 1 {
+		t.Errorf("will %+v, want retained %s on librespeed/node1/availability", s.will, haOffline)
+	}
+	if !s.disconnected {
+		t.Error("connection closed without DISCONNECT, the broker would publish the will")
+	}
+
+	for _, msg := range s.publishes {
+		if !msg.retain || msg.qos != 1 {
+			t.Errorf("%s published with qos %d and retain %v, want retained at qos 1", msg.topic, msg.qos, msg.retain)
+		}
+	}
+
+	// Start announces the sensors and the host online, Send announces them again with the state
+	announced := len(haSensors) + 1
+	if len(s.publishes) != 2*announced+1 {
+		t.Fatalf("%d messages published, want %d", len(s.publishes), 2*announced+1)
+	}
+	for i, sensor := range haSensors {
+		msg := s.publishes[i]
+		if want := "homeassistant/sensor/node1/" + sensor.key + "/config"; msg.topic != want {
+			t.Errorf("discovery topic %s, want %s", msg.topic, want)
+		}
+		var config haConfig
+		if err := json.Unmarshal(msg.payload, &config); err != nil {
+			t.Fatalf("%s: %s", msg.topic, err)
+		}
+		if config.UniqueID != "node1_"+sensor.key || config.StateTopic != "librespeed/node1/state" ||
+			config.ValueTemplate != "{{ value_json."+sensor.key+" }}" ||
+			config.AvailabilityTopic != "librespeed/node1/availability" || config.Device.Identifiers[0] != "node1" {
+			t.Errorf("%s: unexpected config %s", msg.topic, msg.payload)
+		}
+	}
+	if msg := s.publishes[announced-1]; msg.topic != "librespeed/node1/availability" || string(msg.payload) != haOnline {
+		t.Errorf("published %s on %s, want %s on the availability topic", msg.payload, msg.topic, haOnline)
+	}
+
+	state := s.publishes[len(s.publishes)-1]
+	if state.topic != "librespeed/node1/state" {
+		t.Fatalf("last message published on %s, want the state topic", state.topic)
+	}
+	want := `{"ping":12.35,"jitter":1.5,"download":93.46,"upload":41,"server":"Test server","last_run":"2024-05-06T07:08:09Z"}`
+	if string(state.payload) != want {
+		t.Errorf("state %s, want %s", state.payload, want)
+	}
+}
+
+func TestHomeAssistantInterrupted(t *testing.T) {
+	addr, sessions := startBroker(t)
+	h := newTestHomeAssistant(t, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := h.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the connection of an interrupted run is dropped without DISCONNECT
+	h.client.Close()
+
+	s := waitSession(t, sessions)
+	if s.disconnected {
+		t.Error("DISCONNECT sent, the broker would discard the will")
+	}
+	if s.will == nil || s.will.topic != "librespeed/node1/availability" || string(s.will.payload) != haOffline {
+		t.Errorf("will %+v, want %s on librespeed/node1/availability", s.will, haOffline)
+	}
+	if n := len(s.publishes); n != len(haSensors)+1 {
+		t.Errorf("%d messages published, want %d", n, len(haSensors)+1)
+	}
+}
@@ -0,0 +1,142 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"librespeed-cli/defs"
+	"librespeed-cli/report"
+)
+
+func init() {
+	Register("http", newWebhook)
+	Register("https", newWebhook)
+	Register("influxdb", newInfluxDB)
+	Register("influxdb+https", newInfluxDB)
+	Register("pushgateway", newPushgateway)
+	Register("pushgateway+https", newPushgateway)
+}
+
+// httpSink sends the results with a HTTP request. Its client is separate from http.DefaultClient, which carries the
+// headers of the test servers
+type httpSink struct {
+	client      *http.Client
+	method      string
+	url         string
+	contentType string
+	header      http.Header
+	format      string
+}
+
+// newWebhook returns a sink posting the JSON report to a URL
+func newWebhook(u *url.URL, opts Options) (Sink, error) {
+	return &httpSink{
+		client:      &http.Client{Transport: opts.Transport},
+		method:      http.MethodPost,
+		url:         u.String(),
+		contentType: "application/json",
+		format:      report.FormatJSON,
+	}, nil
+}
+
+// newInfluxDB returns a sink writing the results to the v2 write API of InfluxDB, given as
+// influxdb://host:8086?org=ORG&bucket=BUCKET&token=TOKEN
+func newInfluxDB(u *url.URL, opts Options) (Sink, error) {
+	q := u.Query()
+	if q.Get("bucket") == "" {
+		return nil, fmt.Errorf("sink %s has no bucket option", Name(u))
+	}
+
+	write := url.Values{"bucket": {q.Get("bucket")}, "precision": {"ns"}}
+	if org := q.Get("org"); org != "" {
+		write.Set("org", org)
+	}
+	target := url.URL{Scheme: httpScheme(u), User: u.User, Host: u.Host, Path: path.Join("/", u.Path, "api/v2/write"), RawQuery: write.Encode()}
+
+	s := &httpSink{
+		client:      &http.Client{Transport: opts.Transport},
+		method:      http.MethodPost,
+		url:         target.String(),
+		contentType: "text/plain; charset=utf-8",
+		header:      http.Header{},
+		format:      report.FormatInflux,
+	}
+	if token := q.Get("token"); token != "" {
+		s.header.Set("Authorization", "Token "+token)
+	}
+	return s, nil
+}
+
+// newPushgateway returns a sink pushing the metrics to a Prometheus Pushgateway, given as
+// pushgateway://[user:password@]host:9091?job=JOB&instance=INSTANCE. The metrics of the group are replaced on every push
+func newPushgateway(u *url.URL, opts Options) (Sink, error) {
+	q := u.Query()
+	job := q.Get("job")
+	if job == "" {
+		job = "librespeed"
+	}
+
+	elems := []string{"/", u.Path, "metrics/job", job}
+	if instance := q.Get("instance"); instance != "" {
+		elems = append(elems, "instance", instance)
+	}
+	target := url.URL{Scheme: httpScheme(u), User: u.User, Host: u.Host, Path: path.Join(elems...)}
+	return &httpSink{
+		client:      &http.Client{Transport: opts.Transport},
+		method:      http.MethodPut,
+		url:         target.String(),
+		contentType: "text/plain; version=0.0.4",
+		format:      report.FormatProm,
+	}, nil
+}
+
+// httpScheme returns the scheme of the HTTP requests of a sink URL like influxdb+https://
+func httpScheme(u *url.URL) string {
+	if strings.HasSuffix(strings.ToLower(u.Scheme), "+https") {
+		return "https"
+	}
+	return "http"
+}
+
+// Encode implements Sink
+func (h *httpSink) Encode(reps []report.JSONReport) ([]byte, error) {
+	return encode(h.format, reps)
+}
+
+// Send implements Sink
+func (h *httpSink) Send(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, h.method, h.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for key, values := range h.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", h.contentType)
+	req.Header.Set("User-Agent", defs.UserAgent)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+		// client errors are permanent, except timeouts and rate limits
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout &&
+			resp.StatusCode != http.StatusTooManyRequests {
+			return &permanentError{err}
+		}
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
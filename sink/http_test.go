@@ -0,0 +1,140 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"librespeed-cli/report"
+)
+
+// httpRequest is what the test server recorded of a request
+type httpRequest struct {
+	method        string
+	uri           string
+	contentType   string
+	authorization string
+	body          string
+}
+
+func TestHTTPSinks(t *testing.T) {
+	requests := make(chan httpRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- httpRequest{
+			method:        r.Method,
+			uri:           r.RequestURI,
+			contentType:   r.Header.Get("Content-Type"),
+			authorization: r.Header.Get("Authorization"),
+			body:          string(body),
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	tests := []struct {
+		url  string
+		want httpRequest
+		// lines are lines the body must contain, or the whole body if body is set
+		lines []string
+	}{
+		{
+			url: "influxdb://" + host + "?org=home&bucket=speed&token=secret",
+			want: httpRequest{
+				method:        http.MethodPost,
+				uri:           "/api/v2/write?bucket=speed&org=home&precision=ns",
+				contentType:   "text/plain; charset=utf-8",
+				authorization: "Token secret",
+				body: `librespeed,server_id=3,server=Den;Lab\ 1,isp=ISP\,\ Inc ping_ms=12.5,jitter_ms=0.25,` +
+					`download_bps=93456789,upload_bps=41000000,download_bytes=1000i,upload_bytes=500i,` +
+					"data_used_bytes=1500i 1714979289000000000\n",
+			},
+		},
+		{
+			url: "pushgateway://user:pass@" + host + "/prefix?instance=den",
+			want: httpRequest{
+				method:        http.MethodPut,
+				uri:           "/prefix/metrics/job/librespeed/instance/den",
+				contentType:   "text/plain; version=0.0.4",
+				authorization: "Basic dXNlcjpwYXNz",
+			},
+			lines: []string{
+				"# TYPE librespeed_download_bits_per_second gauge",
+				`librespeed_download_bits_per_second{server_id="3",server="Den;Lab 1",isp="ISP, Inc"} 93456789`,
+				`librespeed_ping_seconds{server_id="3",server="Den;Lab 1",isp="ISP, Inc"} 0.0125`,
+			},
+		},
+		{
+			url: ts.URL + "/hook",
+			want: httpRequest{
+				method:      http.MethodPost,
+				uri:         "/hook",
+				contentType: "application/json",
+			},
+			lines: []string{`"name":"Den;Lab 1"`, `"bitrate_bps":93456789`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.SplitN(tt.url, ":", 2)[0], func(t *testing.T) {
+			s, err := New(tt.url, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := s.Encode([]report.JSONReport{testReport()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.Send(ctx, payload); err != nil {
+				t.Fatal(err)
+			}
+
+			got := <-requests
+			if tt.lines != nil {
+				for _, line := range tt.lines {
+					if !strings.Contains(got.body, line) {
+						t.Errorf("body doesn't contain %s:\n%s", line, got.body)
+					}
+				}
+				got.body = ""
+			}
+			if got != tt.want {
+				t.Errorf("request %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPSinkTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var used bool
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		used = true
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	s, err := New(ts.URL, Options{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), []byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	if !used {
+		t.Error("the transport of the options wasn't used")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
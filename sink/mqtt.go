@@ -0,0 +1,375 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"librespeed-cli/report"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttPubAck     = 4
+	mqttPingReq    = 12
+	mqttDisconnect = 14
+)
+
+const (
+	// mqttKeepAlive is the keep alive interval in seconds announced to the broker
+	mqttKeepAlive = 60
+	// mqttWriteTimeout is the deadline of sending PINGREQ and DISCONNECT
+	mqttWriteTimeout = 10 * time.Second
+)
+
+func init() {
+	Register("mqtt", newMQTT)
+	Register("mqtts", newMQTT)
+}
+
+// mqttMessage is an application message of MQTT
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+}
+
+// mqttConfig is the connection of a MQTT sink, given as mqtt[s]://[user:password@]host[:port]/...
+type mqttConfig struct {
+	addr     string
+	tls      bool
+	clientID string
+	username string
+	password string
+	hasUser  bool
+	// dial and tlsConfig connect to the broker, through the proxy and with the TLS settings of the tests
+	dial      dialFunc
+	tlsConfig *tls.Config
+}
+
+// newMQTTConfig returns the connection of a MQTT sink URL, using a random client ID unless given by client_id
+func newMQTTConfig(u *url.URL, opts Options) mqttConfig {
+	cfg := mqttConfig{addr: hostPort(u, "1883"), clientID: u.Query().Get("client_id")}
+	cfg.dial, cfg.tlsConfig = opts.dial(), opts.TLSConfig
+	if scheme := strings.ToLower(u.Scheme); scheme == "mqtts" || strings.HasSuffix(scheme, "+mqtts") {
+		cfg.addr = hostPort(u, "8883")
+		cfg.tls = true
+	}
+	if cfg.clientID == "" {
+		b := make([]byte, 6)
+		rand.Read(b)
+		cfg.clientID = fmt.Sprintf("librespeed-cli-%x", b)
+	}
+	if u.User != nil {
+		cfg.hasUser = true
+		cfg.username = u.User.Username()
+		cfg.password, _ = u.User.Password()
+	}
+	return cfg
+}
+
+// mqttClient is a minimal MQTT 3.1.1 client, publishing messages at QoS 0 or 1 over a single connection. The
+// connection is kept alive until closed, so it can stay open for a whole run
+type mqttClient struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	packetID uint16
+
+	// mu serializes the writes of Publish and the keep alive
+	mu   sync.Mutex
+	done chan struct{}
+	once sync.Once
+}
+
+// dialMQTT connects to the broker within the deadline of ctx. The will is published by the broker if the client
+// disconnects without sending DISCONNECT
+func dialMQTT(ctx context.Context, cfg mqttConfig, will *mqttMessage) (*mqttClient, error) {
+	conn, err := cfg.dial(ctx, "tcp", cfg.addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.tls {
+		tlsConfig := &tls.Config{}
+		if cfg.tlsConfig != nil {
+			tlsConfig = cfg.tlsConfig.Clone()
+		}
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(cfg.addr)
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	m := &mqttClient{conn: conn, r: bufio.NewReader(conn), done: make(chan struct{})}
+	if err := m.connect(ctx, cfg, will); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go m.keepAlive()
+	return m, nil
+}
+
+// connect sends CONNECT and waits for the CONNACK of the broker
+func (m *mqttClient) connect(ctx context.Context, cfg mqttConfig, will *mqttMessage) error {
+	flags := byte(0x02) // clean session
+	var payload []byte
+	payload = appendString(payload, cfg.clientID)
+	if will != nil {
+		flags |= 0x04 | will.qos<<3
+		if will.retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, will.topic)
+		payload = appendBytes(payload, will.payload)
+	}
+	if cfg.hasUser {
+		flags |= 0x80
+		payload = appendString(payload, cfg.username)
+		if cfg.password != "" {
+			flags |= 0x40
+			payload = appendString(payload, cfg.password)
+		}
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, mqttKeepAlive)
+	if err := m.writePacket(ctx, mqttConnect<<4, append(body, payload...)); err != nil {
+		return err
+	}
+
+	packetType, body, err := m.readPacket(ctx)
+	if err != nil {
+		return err
+	}
+	if packetType != mqttConnAck || len(body) != 2 {
+		return fmt.Errorf("unexpected MQTT packet %d instead of CONNACK", packetType)
+	}
+	if code := body[1]; code != 0 {
+		return fmt.Errorf("connection refused by MQTT broker: %s", connAckError(code))
+	}
+	return nil
+}
+
+// Publish sends a message within the deadline of ctx, waiting for its PUBACK at QoS 1
+func (m *mqttClient) Publish(ctx context.Context, msg mqttMessage) error {
+	header := byte(mqttPublish<<4) | msg.qos<<1
+	if msg.retain {
+		header |= 0x01
+	}
+
+	body := appendString(nil, msg.topic)
+	if msg.qos > 0 {
+		m.packetID++
+		if m.packetID == 0 {
+			m.packetID = 1
+		}
+		body = binary.BigEndian.AppendUint16(body, m.packetID)
+	}
+	if err := m.writePacket(ctx, header, append(body, msg.payload...)); err != nil {
+		return err
+	}
+	if msg.qos == 0 {
+		return nil
+	}
+
+	// other packets, like the PINGRESP of the keep alive, are skipped
+	for {
+		packetType, body, err := m.readPacket(ctx)
+		if err != nil {
+			return err
+		}
+		if packetType == mqttPubAck && len(body) == 2 && binary.BigEndian.Uint16(body) == m.packetID {
+			return nil
+		}
+	}
+}
+
+// Disconnect sends DISCONNECT, so the broker discards the will, and closes the connection
+func (m *mqttClient) Disconnect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mqttWriteTimeout)
+	defer cancel()
+
+	err := m.writePacket(ctx, mqttDisconnect<<4, nil)
+	if cerr := m.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close closes the connection without DISCONNECT, so the broker publishes the will
+func (m *mqttClient) Close() error {
+	m.once.Do(func() {
+		close(m.done)
+	})
+	return m.conn.Close()
+}
+
+// keepAlive sends PINGREQ until closed, so the broker doesn't drop the connection during long tests
+func (m *mqttClient) keepAlive() {
+	ticker := time.NewTicker(mqttKeepAlive * time.Second / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), mqttWriteTimeout)
+			err := m.writePacket(ctx, mqttPingReq<<4, nil)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writePacket writes a control packet with its fixed header, within the deadline of ctx
+func (m *mqttClient) writePacket(ctx context.Context, header byte, body []byte) error {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deadline, _ := ctx.Deadline()
+	m.conn.SetWriteDeadline(deadline)
+	_, err := m.conn.Write(append(packet, body...))
+	return err
+}
+
+// readPacket reads a control packet within the deadline of ctx, returning its type and the body following the fixed
+// header
+func (m *mqttClient) readPacket(ctx context.Context) (byte, []byte, error) {
+	deadline, _ := ctx.Deadline()
+	m.conn.SetReadDeadline(deadline)
+
+	header, err := m.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var n, shift int
+	for {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("malformed MQTT remaining length")
+		}
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(m.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header >> 4, body, nil
+}
+
+// appendString appends a length prefixed UTF-8 string
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+// appendBytes appends length prefixed binary data
+func appendBytes(b, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// connAckError returns the meaning of the return code of a CONNACK
+func connAckError(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
+
+// mqttSink publishes the JSON report to a topic, given as mqtt://[user:password@]host:1883/TOPIC?qos=1&retain=true
+type mqttSink struct {
+	cfg    mqttConfig
+	topic  string
+	qos    byte
+	retain bool
+}
+
+// newMQTT returns a MQTT sink, publishing to the topic librespeed unless given
+func newMQTT(u *url.URL, opts Options) (Sink, error) {
+	topic := strings.TrimPrefix(u.Path, "/")
+	if topic == "" {
+		topic = "librespeed"
+	}
+
+	qos, err := queryInt(u, "qos", 1)
+	if err != nil {
+		return nil, err
+	}
+	if qos != 0 && qos != 1 {
+		return nil, fmt.Errorf("unsupported qos option of sink %s: %d, expected 0 or 1", Name(u), qos)
+	}
+	retain, err := queryBool(u, "retain", false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mqttSink{cfg: newMQTTConfig(u, opts), topic: topic, qos: byte(qos), retain: retain}, nil
+}
+
+// Encode implements Sink
+func (s *mqttSink) Encode(reps []report.JSONReport) ([]byte, error) {
+	return encode(report.FormatJSON, reps)
+}
+
+// Send implements Sink
+func (s *mqttSink) Send(ctx context.Context, payload []byte) error {
+	m, err := dialMQTT(ctx, s.cfg, nil)
+	if err != nil {
+		return err
+	}
+	if err := m.Publish(ctx, mqttMessage{topic: s.topic, payload: payload, qos: s.qos, retain: s.retain}); err != nil {
+		m.Close()
+		return err
+	}
+	return m.Disconnect()
+}
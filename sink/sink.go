@@ -0,0 +1,338 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"librespeed-cli/defs"
+	"librespeed-cli/report"
+)
+
+// Sink is a system the results are sent to
+type Sink interface {
+	// Encode returns the results in the format sent to the sink. The payload is spooled as is when the sink is
+	// unreachable
+	Encode(reps []report.JSONReport) ([]byte, error)
+	// Send sends an encoded payload to the sink
+	Send(ctx context.Context, payload []byte) error
+}
+
+// Starter is a sink connecting when the tests start, e.g. to announce the run. Sinks that are also io.Closer are closed
+// once the results are sent
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// NewSinkFunc returns the sink of a URL
+type NewSinkFunc func(u *url.URL, opts Options) (Sink, error)
+
+// Options are the options common to all sinks
+type Options struct {
+	// Timeout is the deadline of each attempt to send the results
+	Timeout time.Duration
+	// Retries is the number of times sending is retried after the first attempt
+	Retries int
+	// SpoolDir is where the results are kept until the sink is reachable again
+	SpoolDir string
+	// Transport sends the requests of the HTTP sinks, http.DefaultTransport if nil
+	Transport http.RoundTripper
+	// Dial connects the sinks sending over TCP or UDP, directly if nil
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// TLSConfig is the TLS configuration of the MQTT sinks over TLS, the server name being set per connection
+	TLSConfig *tls.Config
+}
+
+// dialFunc connects to an address, like net.Dialer.DialContext
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// dial returns the function connecting the sinks sending over TCP or UDP
+func (o Options) dial() dialFunc {
+	if o.Dial != nil {
+		return o.Dial
+	}
+	var d net.Dialer
+	return d.DialContext
+}
+
+// sinks are the registered sinks, by URL scheme
+var sinks = map[string]NewSinkFunc{}
+
+// Register makes a sink available under a URL scheme
+func Register(scheme string, fn NewSinkFunc) {
+	sinks[scheme] = fn
+}
+
+// Schemes returns the URL schemes of the registered sinks
+func Schemes() []string {
+	schemes := make([]string, 0, len(sinks))
+	for scheme := range sinks {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// New returns the sink of a URL. Passwords and tokens in the URL are registered as secrets
+func New(rawURL string, opts Options) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// the error quotes the URL, with its credentials
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid sink URL: %w", err)
+	}
+
+	if password, ok := u.User.Password(); ok {
+		defs.AddSecret(password)
+	}
+	for key, values := range u.Query() {
+		if key == "token" || key == "password" {
+			for _, v := range values {
+				defs.AddSecret(v)
+			}
+		}
+	}
+
+	fn, ok := sinks[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sink %s, expected one of: %s", Name(u), strings.Join(Schemes(), ", "))
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("sink %s has no host", Name(u))
+	}
+	return fn(u, opts)
+}
+
+// Name returns the URL of a sink without credentials or query, for logs
+func Name(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// retryBackoff is the wait before retrying to send, doubled on every attempt
+var retryBackoff = time.Second
+
+// permanentError is a failure to send that trying again won't solve, like a request rejected by the sink
+type permanentError struct {
+	err error
+}
+
+// Error implements error
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the failure to send
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// isPermanent tells whether a failure to send is permanent, in which case the payload is neither retried nor spooled
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// target is a sink results are sent to by a Writer
+type target struct {
+	Sink
+	name  string
+	spool *spool
+}
+
+// Writer sends the results to sinks once all tests are done. Results that can't be sent are spooled, and sent before
+// the results of the next run
+type Writer struct {
+	opts    Options
+	targets []target
+	reps    []report.JSONReport
+}
+
+// NewWriter returns a writer sending the results to the sinks of the URLs given
+func NewWriter(urls []string, opts Options) (*Writer, error) {
+	w := &Writer{opts: opts}
+	for _, rawURL := range urls {
+		s, err := New(rawURL, opts)
+		if err != nil {
+			return nil, err
+		}
+		u, _ := url.Parse(rawURL)
+		w.targets = append(w.targets, target{Sink: s, name: Name(u), spool: newSpool(opts.SpoolDir, rawURL)})
+	}
+	return w, nil
+}
+
+// Begin implements report.Writer. Sinks failing to start still get the results at the end
+func (w *Writer) Begin() error {
+	for _, t := range w.targets {
+		if s, ok := t.Sink.(Starter); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), w.opts.Timeout)
+			if err := s.Start(ctx); err != nil {
+				log.Warnf("Cannot connect to %s, results will be sent at the end: %s", t.name, err)
+			}
+			cancel()
+		}
+	}
+	return nil
+}
+
+// WriteResult implements report.Writer
+func (w *Writer) WriteResult(rep report.JSONReport) error {
+	w.reps = append(w.reps, rep)
+	return nil
+}
+
+// End implements report.Writer. Sinks that can't be reached are logged, and only fail the run if the results can't be
+// spooled either
+func (w *Writer) End() error {
+	defer w.close()
+	if len(w.reps) == 0 {
+		return nil
+	}
+
+	for _, t := range w.targets {
+		payload, err := t.Encode(w.reps)
+		if err != nil {
+			log.Errorf("Error encoding results for %s: %s", t.name, err)
+			return err
+		}
+
+		if err := w.flush(t); err != nil {
+			log.Errorf("Error sending spooled results to %s: %s", t.name, err)
+		} else if err = w.send(t, payload); err == nil {
+			log.Infof("Results sent to %s", t.name)
+			continue
+		} else if isPermanent(err) {
+			log.Errorf("Results rejected by %s: %s", t.name, err)
+			continue
+		} else {
+			log.Errorf("Error sending results to %s: %s", t.name, err)
+		}
+
+		path, err := t.spool.Write(payload)
+		if err != nil {
+			log.Errorf("Error spooling results for %s: %s", t.name, err)
+			return err
+		}
+		log.Warnf("Results for %s spooled to %s", t.name, path)
+	}
+	return nil
+}
+
+// close closes the sinks that are io.Closer
+func (w *Writer) close() {
+	for _, t := range w.targets {
+		if c, ok := t.Sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Debugf("Error closing %s: %s", t.name, err)
+			}
+		}
+	}
+}
+
+// flush sends the results spooled for a sink, oldest first, stopping at the first failure. Results rejected by the
+// sink are dropped, they would be rejected again
+func (w *Writer) flush(t target) error {
+	paths, err := t.spool.List()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		payload, err := t.spool.Read(path)
+		if err != nil {
+			return err
+		}
+		err = w.send(t, payload)
+		if err != nil && !isPermanent(err) {
+			return err
+		}
+		if err := t.spool.Remove(path); err != nil {
+			return err
+		}
+		if err != nil {
+			log.Errorf("Spooled results %s rejected by %s, dropped: %s", path, t.name, err)
+		} else {
+			log.Infof("Spooled results %s sent to %s", path, t.name)
+		}
+	}
+	return nil
+}
+
+// send sends a payload to a sink, retrying with an exponential backoff unless the failure is permanent
+func (w *Writer) send(t target, payload []byte) error {
+	backoff := retryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), w.opts.Timeout)
+		err = t.Send(ctx, payload)
+		cancel()
+		if err == nil || isPermanent(err) || attempt >= w.opts.Retries {
+			return err
+		}
+
+		log.Debugf("Error sending results to %s, retrying in %s: %s", t.name, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// encode returns the results in a report format
+func encode(format string, reps []report.JSONReport) ([]byte, error) {
+	var b strings.Builder
+	w, err := report.NewWriter(format, &b, report.Options{})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Begin(); err != nil {
+		return nil, err
+	}
+	for _, rep := range reps {
+		if err := w.WriteResult(rep); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.End(); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// queryInt returns an integer option of a sink URL, or def if not given
+func queryInt(u *url.URL, key string, def int) (int, error) {
+	v := u.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s option of sink %s: %s", key, Name(u), v)
+	}
+	return i, nil
+}
+
+// queryBool returns a boolean option of a sink URL, or def if not given
+func queryBool(u *url.URL, key string, def bool) (bool, error) {
+	v := u.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s option of sink %s: %s", key, Name(u), v)
+	}
+	return b, nil
+}
@@ -0,0 +1,190 @@
+package sink
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"librespeed-cli/report"
+)
+
+// testReport returns a result with tag values the formats have to escape or replace
+func testReport() report.JSONReport {
+	var rep report.JSONReport
+	rep.Timestamp = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	rep.Server.ID = 3
+	rep.Server.Name = "Den;Lab 1"
+	rep.Client.Org = "ISP, Inc"
+	rep.PingMs = 12.5
+	rep.JitterMs = 0.25
+	rep.Download.BitrateBps = 93456789
+	rep.Upload.BitrateBps = 41000000
+	rep.Download.Bytes = 1000
+	rep.Upload.Bytes = 500
+	rep.DataUsedBytes = 1500
+	return rep
+}
+
+// statusServer is a webhook answering with the given statuses in turn, the last one once all used, and recording the
+// bodies received
+type statusServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func newStatusServer(t *testing.T, statuses ...int) *statusServer {
+	t.Helper()
+	s := &statusServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bodies = append(s.bodies, string(body))
+		status := s.statuses[0]
+		if len(s.statuses) > 1 {
+			s.statuses = s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *statusServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+// sendResults sends a result to the webhook URL with a Writer, returning the spool it uses
+func sendResults(t *testing.T, rawURL string, opts Options, rep report.JSONReport) *spool {
+	t.Helper()
+	w, err := NewWriter([]string{rawURL}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteResult(rep); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	return w.targets[0].spool
+}
+
+func spooled(t *testing.T, s *spool) []string {
+	t.Helper()
+	paths, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestRetry(t *testing.T) {
+	backoff := retryBackoff
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = backoff }()
+
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		spooled  int
+	}{
+		{"success", []int{http.StatusOK}, 1, 0},
+		{"server errors retried", []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusNoContent}, 3, 0},
+		{"rate limit retried", []int{http.StatusTooManyRequests, http.StatusOK}, 2, 0},
+		{"client error not retried", []int{http.StatusBadRequest}, 1, 0},
+		{"retries exhausted", []int{http.StatusBadGateway}, 3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newStatusServer(t, tt.statuses...)
+			opts := Options{Timeout: 5 * time.Second, Retries: 2, SpoolDir: t.TempDir()}
+			s := sendResults(t, ts.URL, opts, testReport())
+
+			if n := len(ts.requests()); n != tt.requests {
+				t.Errorf("%d requests, want %d", n, tt.requests)
+			}
+			if n := len(spooled(t, s)); n != tt.spooled {
+				t.Errorf("%d payloads spooled, want %d", n, tt.spooled)
+			}
+		})
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	backoff := retryBackoff
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = backoff }()
+
+	ts := newStatusServer(t, http.StatusServiceUnavailable)
+	opts := Options{Timeout: 5 * time.Second, SpoolDir: t.TempDir()}
+
+	first := testReport()
+	first.Server.Name = "first"
+	s := sendResults(t, ts.URL, opts, first)
+	paths := spooled(t, s)
+	if len(paths) != 1 {
+		t.Fatalf("%d payloads spooled, want 1", len(paths))
+	}
+	payload, err := s.Read(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(payload), `"name":"first"`) {
+		t.Errorf("spooled payload %s, want the first result", payload)
+	}
+	if info, err := os.Stat(paths[0]); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("spool file mode %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+
+	// the sink is back, the spooled results are sent before the new ones
+	ts.mu.Lock()
+	ts.statuses = []int{http.StatusOK}
+	ts.bodies = nil
+	ts.mu.Unlock()
+
+	second := testReport()
+	second.Server.Name = "second"
+	sendResults(t, ts.URL, opts, second)
+
+	bodies := ts.requests()
+	if len(bodies) != 2 || bodies[0] != string(payload) || !strings.Contains(bodies[1], `"name":"second"`) {
+		t.Errorf("sent %q, want the spooled results then the second result", bodies)
+	}
+	if n := len(spooled(t, s)); n != 0 {
+		t.Errorf("%d payloads left in the spool, want 0", n)
+	}
+}
+
+func TestSpoolDropsRejected(t *testing.T) {
+	ts := newStatusServer(t, http.StatusBadRequest)
+	opts := Options{Timeout: 5 * time.Second, SpoolDir: t.TempDir()}
+
+	s := newSpool(opts.SpoolDir, ts.URL)
+	if _, err := s.Write([]byte(`[]`)); err != nil {
+		t.Fatal(err)
+	}
+	sendResults(t, ts.URL, opts, testReport())
+
+	if n := len(ts.requests()); n != 2 {
+		t.Errorf("%d requests, want the spooled and the new results", n)
+	}
+	if n := len(spooled(t, s)); n != 0 {
+		t.Errorf("%d payloads left in the spool, want the rejected one dropped", n)
+	}
+}
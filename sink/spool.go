@@ -0,0 +1,70 @@
+package sink
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// spoolExt is the extension of spooled results
+const spoolExt = ".spool"
+
+// spool keeps the payloads of a sink in files until it's reachable again. Files are named by a hash of the sink URL,
+// so the URL and its credentials aren't stored
+type spool struct {
+	dir    string
+	prefix string
+}
+
+func newSpool(dir, rawURL string) *spool {
+	sum := sha256.Sum256([]byte(rawURL))
+	return &spool{dir: dir, prefix: fmt.Sprintf("%x-", sum[:8])}
+}
+
+// Write spools a payload, returning the path of its file
+func (s *spool) Write(payload []byte) (string, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%s%d%s", s.prefix, time.Now().UnixNano(), spoolExt))
+	if err := os.WriteFile(path, payload, 0600); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// List returns the paths of the spooled payloads, oldest first
+func (s *spool) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, e := range entries {
+		if name := e.Name(); strings.HasPrefix(name, s.prefix) && strings.HasSuffix(name, spoolExt) {
+			paths = append(paths, filepath.Join(s.dir, name))
+		}
+	}
+	// the timestamps are of the same length until 2286
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Read returns a spooled payload
+func (s *spool) Read(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// Remove removes a spooled payload once sent
+func (s *spool) Remove(path string) error {
+	return os.Remove(path)
+}
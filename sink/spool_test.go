@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spool")
+	s := newSpool(dir, "influxdb://host?token=secret")
+	other := newSpool(dir, "mqtt://host")
+
+	if paths, err := s.List(); err != nil || len(paths) != 0 {
+		t.Fatalf("missing spool directory listed %v, %v, want no payload", paths, err)
+	}
+
+	var written []string
+	for _, payload := range []string{"one", "two", "three"} {
+		path, err := s.Write([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(path, "secret") {
+			t.Errorf("spool file %s contains the token of the URL", path)
+		}
+		written = append(written, path)
+	}
+	if _, err := other.Write([]byte("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(paths, ",") != strings.Join(written, ",") {
+		t.Fatalf("listed %v, want %v oldest first, without the payloads of other sinks", paths, written)
+	}
+	for i, payload := range []string{"one", "two", "three"} {
+		b, err := s.Read(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != payload {
+			t.Errorf("read %q from %s, want %q", b, paths[i], payload)
+		}
+	}
+
+	if err := s.Remove(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := s.List(); len(paths) != 2 {
+		t.Errorf("%d payloads left, want 2", len(paths))
+	}
+	if paths, _ := other.List(); len(paths) != 1 {
+		t.Errorf("%d payloads of the other sink, want 1", len(paths))
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("spool directory mode %v, want 0700", info.Mode().Perm())
+	}
+}
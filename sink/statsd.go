@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"librespeed-cli/report"
+)
+
+// statsdPacketSize is the largest UDP packet sent to StatsD, fitting in the MTU of most links
+const statsdPacketSize = 1432
+
+func init() {
+	Register("statsd", newStatsD)
+	Register("dogstatsd", newStatsD)
+}
+
+// statsdSink sends the results to StatsD as gauges over UDP. Plain StatsD has no tags, so the server ID is part of
+// the metric name, while DogStatsD gets the tags of the results
+type statsdSink struct {
+	dial   dialFunc
+	addr   string
+	prefix string
+	tags   bool
+}
+
+// newStatsD returns a sink given as statsd://host:8125?prefix=PREFIX or dogstatsd://host:8125?prefix=PREFIX
+func newStatsD(u *url.URL, opts Options) (Sink, error) {
+	prefix := u.Query().Get("prefix")
+	if prefix == "" {
+		prefix = "librespeed"
+	}
+	return &statsdSink{dial: opts.dial(), addr: hostPort(u, "8125"), prefix: prefix, tags: strings.EqualFold(u.Scheme, "dogstatsd")}, nil
+}
+
+// Encode implements Sink
+func (s *statsdSink) Encode(reps []report.JSONReport) ([]byte, error) {
+	var b strings.Builder
+	for _, rep := range reps {
+		name := fmt.Sprintf("%s.server_%d.", s.prefix, rep.Server.ID)
+		var tags string
+		if s.tags {
+			name = s.prefix + "."
+			var list []string
+			for _, tag := range report.ResultTags(rep) {
+				list = append(list, tag.Key+":"+statsdTag(tag.Value))
+			}
+			tags = "|#" + strings.Join(list, ",")
+		}
+
+		for _, field := range report.ResultFields(rep) {
+			fmt.Fprintf(&b, "%s%s:%s|g%s\n", name, field.Key, report.FormatValue(field), tags)
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// Send implements Sink. The lines of the payload are sent in as few packets as possible
+func (s *statsdSink) Send(ctx context.Context, payload []byte) error {
+	var packet string
+	for _, line := range strings.SplitAfter(string(payload), "\n") {
+		if packet != "" && len(packet)+len(line) > statsdPacketSize {
+			if err := sendStream(ctx, s.dial, "udp", s.addr, []byte(packet)); err != nil {
+				return err
+			}
+			packet = ""
+		}
+		packet += line
+	}
+	if packet == "" {
+		return nil
+	}
+	return sendStream(ctx, s.dial, "udp", s.addr, []byte(packet))
+}
+
+// statsdTag replaces the characters not allowed in the tag values of DogStatsD
+func statsdTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ',' || r == '|' || r == '#' || r == '\n' {
+			return '_'
+		}
+		return r
+	}, s)
+}
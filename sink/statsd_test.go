@@ -0,0 +1,113 @@
+package sink
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"librespeed-cli/report"
+)
+
+// sendStatsD sends the results to a StatsD sink listening on UDP, returning the packets received
+func sendStatsD(t *testing.T, scheme string, reps []report.JSONReport) []string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := New(scheme+"://"+conn.LocalAddr().String(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := s.Encode(reps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.Send(ctx, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []string
+	var received int
+	buf := make([]byte, 65536)
+	for received < len(payload) {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("received %d of %d bytes: %s", received, len(payload), err)
+		}
+		packets = append(packets, string(buf[:n]))
+		received += n
+	}
+	return packets
+}
+
+func TestStatsD(t *testing.T) {
+	tests := []struct {
+		scheme string
+		want   []string
+	}{
+		{"statsd", []string{
+			"librespeed.server_3.ping_ms:12.5|g",
+			"librespeed.server_3.jitter_ms:0.25|g",
+			"librespeed.server_3.download_bps:93456789|g",
+			"librespeed.server_3.upload_bps:41000000|g",
+			"librespeed.server_3.download_bytes:1000|g",
+			"librespeed.server_3.upload_bytes:500|g",
+			"librespeed.server_3.data_used_bytes:1500|g",
+		}},
+		{"dogstatsd", []string{
+			"librespeed.ping_ms:12.5|g|#server_id:3,server:Den;Lab 1,isp:ISP_ Inc",
+			"librespeed.jitter_ms:0.25|g|#server_id:3,server:Den;Lab 1,isp:ISP_ Inc",
+			"librespeed.download_bps:93456789|g|#server_id:3,server:Den;Lab 1,isp:ISP_ Inc",
+			"librespeed.upload_bps:41000000|g|#server_id:3,server:Den;Lab 1,isp:ISP_ Inc",
+			"librespeed.download_bytes:1000|g|#server_id:3,server:Den;Lab 1,isp:ISP_ Inc",
+			"librespeed.upload_bytes:500|g|#server_id:3,server:Den;Lab 1,isp:ISP_ Inc",
+			"librespeed.data_used_bytes:1500|g|#server_id:3,server:Den;Lab 1,isp:ISP_ Inc",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			packets := sendStatsD(t, tt.scheme, []report.JSONReport{testReport()})
+			if len(packets) != 1 {
+				t.Fatalf("%d packets received, want 1", len(packets))
+			}
+			if got, want := packets[0], strings.Join(tt.want, "\n")+"\n"; got != want {
+				t.Errorf("received:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestStatsDPackets(t *testing.T) {
+	reps := make([]report.JSONReport, 30)
+	for i := range reps {
+		reps[i] = testReport()
+		reps[i].Server.ID = i
+	}
+
+	packets := sendStatsD(t, "dogstatsd", reps)
+	if len(packets) < 2 {
+		t.Fatalf("%d packets received, want the lines split over several packets", len(packets))
+	}
+	var lines int
+	for _, packet := range packets {
+		if len(packet) > statsdPacketSize {
+			t.Errorf("packet of %d bytes, larger than %d", len(packet), statsdPacketSize)
+		}
+		if !strings.HasSuffix(packet, "\n") {
+			t.Errorf("packet ends within a line: %q", packet[len(packet)-20:])
+		}
+		lines += strings.Count(packet, "\n")
+	}
+	if want := len(reps) * len(report.ResultFields(reps[0])); lines != want {
+		t.Errorf("%d lines received, want %d", lines, want)
+	}
+}
@@ -0,0 +1,228 @@
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"librespeed-cli/defs"
+)
+
+const (
+	// dohContentType is the media type of DNS messages in DNS-over-HTTPS requests
+	dohContentType = "application/dns-message"
+)
+
+// newResolver returns a resolver sending all queries to the DNS server given in --dns-server, which can be an IP address
+// with optional port, a udp:// or tcp:// URL, or a https:// DNS-over-HTTPS endpoint. The control function, if any,
+// binds the sockets used for queries
+func newResolver(server string, control func(network, address string, c syscall.RawConn) error) (*net.Resolver, error) {
+	if !strings.Contains(server, "://") {
+		server = "udp://" + server
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return nil, errors.New("DNS server address is missing")
+		}
+		address := u.Host
+		if u.Port() == "" {
+			address = net.JoinHostPort(strings.Trim(u.Host, "[]"), "53")
+		}
+		network := u.Scheme
+		dialer := &net.Dialer{Timeout: 5 * time.Second, Control: control}
+
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+		}, nil
+	case "https":
+		endpoint := u.String()
+		// the DoH endpoint itself is resolved by the system resolver
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if control != nil {
+			dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: control}
+			transport.DialContext = dialer.DialContext
+		}
+		client := &http.Client{
+			Transport: transport,
+			Timeout:   10 * time.Second,
+		}
+
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return &dohConn{ctx: ctx, client: client, endpoint: endpoint}, nil
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DNS server scheme: %s", u.Scheme)
+	}
+}
+
+// newBoundResolver returns a resolver querying the system's DNS servers through sockets bound by the control function
+func newBoundResolver(control func(network, address string, c syscall.RawConn) error) *net.Resolver {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: control}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     dialer.DialContext,
+	}
+}
+
+// parseResolveOverrides parses the --resolve entries in curl's host:port:addr format into a map of "host:port" to
+// "addr:port"
+func parseResolveOverrides(entries []string) (map[string]string, error) {
+	ret := make(map[string]string)
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid --resolve entry %s, expecting host:port:addr", entry)
+		}
+
+		addr := strings.Trim(parts[2], "[]")
+		if net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("invalid address in --resolve entry %s", entry)
+		}
+
+		ret[net.JoinHostPort(strings.ToLower(parts[0]), parts[1])] = net.JoinHostPort(addr, parts[1])
+	}
+	return ret, nil
+}
+
+// resolveOverride returns the address from --resolve for the "host:port" address, or the address itself
+func resolveOverride(overrides map[string]string, address string) string {
+	if override, ok := overrides[strings.ToLower(address)]; ok {
+		return override
+	}
+	return address
+}
+
+// dohConn is a net.Conn for the Go resolver that sends each DNS query in a DNS-over-HTTPS POST request. The resolver
+// treats it as a stream connection, so messages are prefixed by their 2 bytes length
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	endpoint string
+	deadline time.Time
+
+	wbuf bytes.Buffer
+	rbuf bytes.Buffer
+}
+
+// Write implements io.Writer, sending every complete query to the DoH endpoint
+func (c *dohConn) Write(p []byte) (int, error) {
+	c.wbuf.Write(p)
+
+	for c.wbuf.Len() >= 2 {
+		size := int(binary.BigEndian.Uint16(c.wbuf.Bytes()[:2]))
+		if c.wbuf.Len() < size+2 {
+			break
+		}
+		msg := make([]byte, size)
+		copy(msg, c.wbuf.Bytes()[2:size+2])
+		c.wbuf.Next(size + 2)
+
+		resp, err := c.query(msg)
+		if err != nil {
+			return 0, err
+		}
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+		c.rbuf.Write(length[:])
+		c.rbuf.Write(resp)
+	}
+
+	return len(p), nil
+}
+
+// query sends a single DNS message to the DoH endpoint and returns the answer
+func (c *dohConn) query(msg []byte) ([]byte, error) {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	req.Header.Set("User-Agent", defs.UserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Read implements io.Reader, returning the answers received so far
+func (c *dohConn) Read(p []byte) (int, error) {
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.rbuf.Read(p)
+}
+
+// Close implements net.Conn
+func (c *dohConn) Close() error {
+	return nil
+}
+
+// LocalAddr implements net.Conn
+func (c *dohConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// RemoteAddr implements net.Conn
+func (c *dohConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// SetDeadline implements net.Conn
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+// SetReadDeadline implements net.Conn
+func (c *dohConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
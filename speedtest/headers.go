@@ -0,0 +1,187 @@
+package speedtest
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"librespeed-cli/defs"
+)
+
+// sensitiveHeaderWords are the words in header names whose values are redacted from logs
+var sensitiveHeaderWords = []string{"authorization", "cookie", "token", "key", "secret", "password", "session", "auth"}
+
+// requestHeaders holds the headers added to all requests, and the ones added to the requests of each server host
+type requestHeaders struct {
+	global http.Header
+
+	mu    sync.RWMutex
+	hosts map[string]http.Header
+}
+
+// newRequestHeaders parses the --header entries in "Name: value" format, and the bearer token or "user:password"
+// basic auth credentials into the headers added to all requests. An empty value removes the header from requests
+func newRequestHeaders(entries []string, bearer, basic string) (*requestHeaders, error) {
+	global := make(http.Header)
+	for _, entry := range entries {
+		name, value, err := parseHeader(entry)
+		if err != nil {
+			return nil, err
+		}
+		global[name] = append(global[name], value)
+	}
+
+	if bearer != "" && basic != "" {
+		return nil, errors.New("bearer and basic authentication can't be used together")
+	}
+	if bearer != "" {
+		defs.AddSecret(bearer)
+		global.Set("Authorization", "Bearer "+bearer)
+	}
+	if basic != "" {
+		user, password, found := strings.Cut(basic, ":")
+		if !found {
+			return nil, errors.New("basic authentication credentials must be in user:password format")
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+		defs.AddSecret(password)
+		defs.AddSecret(credentials)
+		global.Set("Authorization", "Basic "+credentials)
+	}
+
+	return &requestHeaders{global: global}, nil
+}
+
+// setServers replaces the per host headers with the ones of the given servers
+func (h *requestHeaders) setServers(servers []defs.Server) error {
+	hosts := make(map[string]http.Header)
+	for _, server := range servers {
+		if len(server.Headers) == 0 {
+			continue
+		}
+
+		u, err := url.Parse(server.Server)
+		if err != nil {
+			return err
+		}
+
+		header := make(http.Header)
+		for name, value := range server.Headers {
+			name, value, err := parseHeader(name + ":" + value)
+			if err != nil {
+				return fmt.Errorf("invalid header for server %s: %s", server.Name, err)
+			}
+			header.Set(name, value)
+		}
+		hosts[u.Host] = header
+	}
+
+	h.mu.Lock()
+	h.hosts = hosts
+	h.mu.Unlock()
+	return nil
+}
+
+// apply sets the headers on the request, the per host headers overriding the global ones. When following a redirect to
+// another host than the one first requested, the sensitive global headers aren't sent, like net/http does for its own
+func (h *requestHeaders) apply(req *http.Request) {
+	h.mu.RLock()
+	host := h.hosts[req.URL.Host]
+	h.mu.RUnlock()
+
+	origin := req
+	for origin.Response != nil && origin.Response.Request != nil {
+		origin = origin.Response.Request
+	}
+	crossHost := origin.URL.Host != req.URL.Host
+
+	for i, header := range []http.Header{h.global, host} {
+		for name, values := range header {
+			if i == 0 && crossHost && isSensitiveHeader(name) {
+				log.Debugf("Not sending header %s to %s after a redirect", name, req.URL.Host)
+				continue
+			}
+			req.Header.Del(name)
+			for _, value := range values {
+				if value != "" {
+					req.Header.Add(name, value)
+				}
+			}
+			// an empty User-Agent prevents Go from sending its default one
+			if name == "User-Agent" && len(req.Header.Values(name)) == 0 {
+				req.Header.Set(name, "")
+			}
+		}
+	}
+}
+
+// wrap returns a round tripper adding the headers to the requests of the given one, or the given one if there are no
+// headers to add
+func (h *requestHeaders) wrap(rt http.RoundTripper) http.RoundTripper {
+	if h == nil {
+		return rt
+	}
+	return &headerTransport{base: rt, headers: h}
+}
+
+// headerTransport is a HTTP round tripper adding the custom headers to requests
+type headerTransport struct {
+	base    http.RoundTripper
+	headers *requestHeaders
+}
+
+// RoundTrip adds the headers to a copy of the request, and sends it with the underlying round tripper
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	t.headers.apply(req)
+	return t.base.RoundTrip(req)
+}
+
+// Close releases the resources of the underlying round tripper
+func (t *headerTransport) Close() error {
+	if closer, ok := t.base.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// parseHeader parses a header in "Name: value" format, registering the value as a secret if the name looks sensitive
+func parseHeader(entry string) (string, string, error) {
+	name, value, found := strings.Cut(entry, ":")
+	name = strings.TrimSpace(name)
+	if !found || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("invalid header %q, must be in \"Name: value\" format", entry)
+	}
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	value = strings.TrimSpace(value)
+
+	if isSensitiveHeader(name) {
+		defs.AddSecret(value)
+		// only the credentials of schemes like "Bearer <token>" are secret
+		if _, credentials, found := strings.Cut(value, " "); found {
+			defs.AddSecret(strings.TrimSpace(credentials))
+		}
+	}
+
+	log.Debugf("Adding header %s: %s", name, value)
+	return name, value, nil
+}
+
+// isSensitiveHeader returns whether the header name looks like it holds credentials
+func isSensitiveHeader(name string) bool {
+	lower := strings.ToLower(name)
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
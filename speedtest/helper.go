@@ -2,7 +2,9 @@ package speedtest
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -10,30 +12,73 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
-	"github.com/briandowns/spinner"
-	"github.com/gocarina/gocsv"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
 	"librespeed-cli/defs"
 	"librespeed-cli/report"
+	"librespeed-cli/units"
 )
 
 const (
 	// the default ping count for measuring ping and jitter
 	pingCount = 10
+
+	// thresholds for flagging an IP version as significantly slower in dual stack mode: throughput below 70% of the
+	// other version, or ping 50% and 10 ms higher
+	dualStackSlowRatio = 0.7
+	dualStackPingRatio = 1.5
+	dualStackPingDiff  = 10
 )
 
 // doSpeedTest is where the actual speed test happens
-func doSpeedTest(c *cli.Context, servers []defs.Server, telemetryServer defs.TelemetryServer, network string, silent bool) error {
+func doSpeedTest(c *cli.Context, servers []defs.Server, telemetryServer defs.TelemetryServer, network, format string, silent bool, transports []testTransport, w report.Writer) error {
+	baseTransport := http.DefaultClient.Transport
+	defer func() {
+		http.DefaultClient.Transport = baseTransport
+	}()
+
+	// data caps and rate limit for metered links
+	maxBytes, err := units.ParseSize(c.String(defs.OptionMaxBytes))
+	if err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
+	maxTotalBytes, err := units.ParseSize(c.String(defs.OptionMaxTotalBytes))
+	if err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
+	rateLimit, err := units.ParseRate(c.String(defs.OptionRateLimit))
+	if err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
+	var totalUsed int64
+	// runErr is returned once all servers are tested, for the failures that make the run unsuccessful
+	var runErr error
+
+	// the upload payload is shared by all upload tests, readers are created for each request
+	payloadKind := c.String(defs.OptionUploadPayload)
+	if c.Bool(defs.OptionNoPreAllocate) {
+		log.Info("Pre-allocation is disabled, using a pseudo-random upload payload")
+		payloadKind = defs.PayloadPRNG
+	}
+	payload, err := defs.NewPayloadSource(payloadKind, c.Int(defs.OptionUploadSize)*1024)
+	if err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
+
 	if serverCount := len(servers); serverCount > 1 {
 		log.Infof("Testing against %d servers", serverCount)
 	}
 
-	var reps_json []report.JSONReport
-	var reps_csv []report.CSVReport
+	// warnings are part of the report unless the output is for humans
+	human := format == report.FormatText || format == report.FormatSimple
 
 	// fetch current user's IP info
 	for _, currentServer := range servers {
@@ -52,136 +97,267 @@ func doSpeedTest(c *cli.Context, servers []defs.Server, telemetryServer defs.Tel
 			log.Infof("Sponsored by: %s", sponsorMsg)
 		}
 
-		if currentServer.IsUp() {
-			ispInfo, err := currentServer.GetIPInfo(c.String(defs.OptionDistance))
-			if err != nil {
-				log.Errorf("Failed to get IP info: %s", err)
-				return err
-			}
-			log.Infof("You're testing from: %s", ispInfo.ProcessedString)
-
-			// get ping and jitter value
-			var pb *spinner.Spinner
-			if !silent {
-				pb = spinner.New(spinner.CharSets[11], 100*time.Millisecond)
-				pb.Prefix = "Pinging server...  "
-				pb.Start()
-			}
-
-			// skip ICMP if option given
-			currentServer.NoICMP = c.Bool(defs.OptionNoICMP)
-			currentServer.IncrementalProgress = c.Bool(defs.OptionJSONL)
-
-			// send header info if running in JSONL mode
-			if c.Bool(defs.OptionJSONL) {
-				defs.SendProgressHeader(&currentServer, &ispInfo.RawISPInfo)
+		var comparison []comparisonResult
+		// the reports of this server are written once the dual stack warnings are attached
+		var reps []report.JSONReport
+		// the HTTP version of each report for this server, to attach dual stack warnings
+		reportVersions := make(map[int]string)
+		// why the forced HTTP versions couldn't be used, errors are reported for the ones not used over any IP version
+		versionErrs := make(map[string]error)
+		versionUsed := make(map[string]bool)
+		for _, transport := range transports {
+			// the addresses and protocol are recorded again for every transport
+			currentServer.IP = ""
+			currentServer.LocalIP = ""
+			currentServer.Protocol = ""
+			currentServer.TLSVersion = ""
+			currentServer.TLSCipher = ""
+
+			testNetwork := network
+			if transport.network != "" {
+				testNetwork = transport.network
+				log.Infof("Testing over %s", familyName(transport.network))
 			}
 
-			p, jitter, err := currentServer.ICMPPingAndJitter(pingCount, c.String(defs.OptionSource), network)
-			if err != nil {
-				log.Errorf("Failed to get ping and jitter: %s", err)
-				return err
+			if transport.version != "" {
+				if transport.version == httpVersion3 && u.Scheme != "https" {
+					versionErrs[transport.version] = errors.New("HTTP/3 requires HTTPS")
+					continue
+				}
+				log.Infof("Testing with HTTP/%s", transport.version)
 			}
+			http.DefaultClient.Transport = transport.rt
 
-			if pb != nil {
-				pb.FinalMSG = fmt.Sprintf("Ping: %.0f ms\tJitter: %.0f ms\n", p, jitter)
-				pb.Stop()
+			upErr := currentServer.IsUp(context.Background())
+			// TLS failures are reported on their own
+			if upErr != nil && transport.version != "" && !isTLSError(upErr) {
+				versionErrs[transport.version] = upErr
 			}
-
-			// get download value
-			var downloadResult defs.TransferSummaryResponse
-			if c.Bool(defs.OptionNoDownload) {
-				log.Info("Download test is disabled")
-			} else {
-				result, err := currentServer.Download(silent, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes), c.Int(defs.OptionConcurrent), c.Int(defs.OptionChunks), time.Duration(c.Int(defs.OptionDuration))*time.Second)
+			if upErr == nil {
+				versionUsed[transport.version] = true
+				ispInfo, err := currentServer.GetIPInfo(context.Background(), c.String(defs.OptionDistance))
 				if err != nil {
-					log.Errorf("Failed to get download speed: %s", err)
+					log.Errorf("Failed to get IP info: %s", err)
+					// in dual stack mode, go on with the other IP version so the missing one is flagged
+					if transport.network != "" {
+						continue
+					}
 					return err
 				}
-				downloadResult = result
-			}
+				log.Infof("You're testing from: %s", ispInfo.ProcessedString)
+				log.Infof("Protocol: %s", currentServer.Protocol)
+				if currentServer.TLSVersion != "" {
+					log.Infof("TLS: %s, %s", currentServer.TLSVersion, currentServer.TLSCipher)
+				}
+
+				// the address connected to is the proxy's when a proxy is used
+				proxyUrl := proxyFor(u)
+				if proxyUrl != nil {
+					currentServer.IP = ""
+				} else if currentServer.IP != "" {
+					log.Infof("Server address: %s", currentServer.IP)
+				}
+
+				// get ping and jitter value
+				var pb *defs.Progress
+				if !silent {
+					pb = defs.NewProgress("Pinging server...", nil)
+					pb.Start()
+				}
 
-			// get upload value
-			var uploadResult defs.TransferSummaryResponse
-			if c.Bool(defs.OptionNoUpload) {
-				log.Info("Upload test is disabled")
-			} else {
-				result, err := currentServer.Upload(c.Bool(defs.OptionNoPreAllocate), silent, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes), c.Int(defs.OptionConcurrent), c.Int(defs.OptionUploadSize), time.Duration(c.Int(defs.OptionDuration))*time.Second)
+				// skip ICMP if option given, or if the server is reached through a proxy since ICMP can't be proxied
+				if proxyUrl != nil {
+					log.Infof("Using proxy: %s", redactURL(proxyUrl))
+				}
+				currentServer.NoICMP = c.Bool(defs.OptionNoICMP) || proxyUrl != nil
+				currentServer.IncrementalProgress = progressEvents(c, format)
+				currentServer.Interface = c.String(defs.OptionInterface)
+
+				// send header info if running in JSONL mode or with the live view
+				if progressEvents(c, format) {
+					defs.SendProgressHeader(&currentServer, &ispInfo.RawISPInfo)
+				}
+
+				p, jitter, err := currentServer.ICMPPingAndJitter(context.Background(), pingCount, c.String(defs.OptionSource), testNetwork)
 				if err != nil {
-					log.Errorf("Failed to get upload speed: %s", err)
+					log.Errorf("Failed to get ping and jitter: %s", err)
+					if pb != nil {
+						pb.Stop("")
+					}
+					if transport.network != "" {
+						continue
+					}
 					return err
 				}
-				uploadResult = result
-			}
 
-			// print result if --simple is given
-			if c.Bool(defs.OptionSimple) {
-				if c.Bool(defs.OptionBytes) {
-					useMebi := c.Bool(defs.OptionMebiBytes)
-					log.Warnf("Ping:\t%.0f ms\tJitter:\t%.0f ms\nDownload rate:\t%s\nUpload rate:\t%s", p, jitter, humanizeMbps(downloadResult.Bitrate, useMebi), humanizeMbps(uploadResult.Bitrate, useMebi))
-				} else {
-					log.Warnf("Ping:\t%.0f ms\tJitter:\t%.0f ms\nDownload rate:\t%.2f Mbps\nUpload rate:\t%.2f Mbps", p, jitter, downloadResult.Bitrate, uploadResult.Bitrate)
+				if pb != nil {
+					pb.Stop(fmt.Sprintf("Ping: %.0f ms\tJitter: %.0f ms\n", p, jitter))
 				}
-			}
-
-			// print share link if --share is given
-			var shareLink string
-			if telemetryServer.GetLevel() > 0 {
-				var extra defs.TelemetryExtra
-				extra.ServerName = currentServer.Name
-				extra.Extra = c.String(defs.OptionTelemetryExtra)
 
-				if link, err := sendTelemetry(telemetryServer, ispInfo, downloadResult.Bitrate, uploadResult.Bitrate, p, jitter, currentServer.TLog.String(), extra); err != nil {
-					log.Errorf("Error when sending telemetry data: %s", err)
+				// get download value
+				var downloadResult defs.TransferSummaryResponse
+				if c.Bool(defs.OptionNoDownload) {
+					log.Info("Download test is disabled")
+				} else if limit, ok := transferCap(maxBytes, maxTotalBytes, totalUsed); !ok {
+					log.Info("Data cap reached, skipping download test")
+					downloadResult.EndReason = defs.EndReasonSkippedByteCap
 				} else {
-					shareLink = link
-					// only print to stdout when --json and --csv are not used
-					if !c.Bool(defs.OptionJSON) && !c.Bool(defs.OptionJSONL) && !c.Bool(defs.OptionCSV) {
-						log.Warnf("Share your result: %s", link)
+					currentServer.MaxBytes = limit
+					currentServer.RateLimit = rateLimit
+					result, err := currentServer.Download(silent, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes), c.Int(defs.OptionConcurrent), c.Int(defs.OptionChunks), time.Duration(c.Int(defs.OptionDuration))*time.Second)
+					if err != nil {
+						log.Errorf("Failed to get download speed: %s", err)
+						if transport.network != "" {
+							continue
+						}
+						return err
 					}
+					downloadResult = result
+					totalUsed += int64(result.TotalBytes)
+					if result.EndReason == defs.EndReasonByteCap {
+						log.Info("Download test stopped at the data cap")
+					}
+					logInterfaceCounters("download", result.Interface, human)
 				}
-			}
 
-			// check for --csv or --json. the program prioritize the --csv before the --json. this is the same behavior as speedtest-cli
-			if c.Bool(defs.OptionCSV) {
-				// print csv if --csv is given
-				var rep report.CSVReport
-				rep.Timestamp = time.Now()
+				// get upload value
+				var uploadResult defs.TransferSummaryResponse
+				if c.Bool(defs.OptionNoUpload) {
+					log.Info("Upload test is disabled")
+				} else if limit, ok := transferCap(maxBytes, maxTotalBytes, totalUsed); !ok {
+					log.Info("Data cap reached, skipping upload test")
+					uploadResult.EndReason = defs.EndReasonSkippedByteCap
+				} else {
+					currentServer.MaxBytes = limit
+					currentServer.RateLimit = rateLimit
+					result, err := currentServer.Upload(payload, silent, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes), c.Int(defs.OptionConcurrent), c.Int(defs.OptionUploadSize), time.Duration(c.Int(defs.OptionDuration))*time.Second)
+					if err != nil {
+						log.Errorf("Failed to get upload speed: %s", err)
+						if transport.network != "" {
+							continue
+						}
+						return err
+					}
+					uploadResult = result
+					totalUsed += int64(result.TotalBytes)
+					if result.EndReason == defs.EndReasonByteCap {
+						log.Info("Upload test stopped at the data cap")
+					}
+					logInterfaceCounters("upload", result.Interface, human)
+				}
 
-				rep.Name = currentServer.Name
-				rep.Address = u.String()
-				rep.Ping = p
-				rep.Jitter = math.Round(jitter*100) / 100
-				rep.Download = math.Round(downloadResult.Bitrate*100) / 100
-				rep.Upload = math.Round(uploadResult.Bitrate*100) / 100
-				rep.Share = shareLink
-				rep.IP = ispInfo.RawISPInfo.IP
+				dataUsed := int64(downloadResult.TotalBytes + uploadResult.TotalBytes)
+				log.Infof("Data used: %s", units.Units{IEC: c.Bool(defs.OptionMebiBytes)}.Size(dataUsed))
+
+				comparison = append(comparison, comparisonResult{
+					version:  transport.version,
+					network:  transport.network,
+					protocol: currentServer.Protocol,
+					address:  currentServer.IP,
+					ping:     p,
+					jitter:   jitter,
+					download: report.NewTransfer(downloadResult).BitrateBps,
+					upload:   report.NewTransfer(uploadResult).BitrateBps,
+				})
+
+				// get a share link if --share is given
+				var shareLink string
+				if telemetryServer.GetLevel() > 0 {
+					// telemetry is not bound to the HTTP version being tested
+					http.DefaultClient.Transport = baseTransport
+
+					var extra defs.TelemetryExtra
+					extra.ServerName = currentServer.Name
+					extra.Extra = c.String(defs.OptionTelemetryExtra)
+
+					if link, err := sendTelemetry(telemetryServer, ispInfo, downloadResult.Bitrate, uploadResult.Bitrate, p, jitter, currentServer.TLog.String(), extra); err != nil {
+						log.Errorf("Error when sending telemetry data: %s", err)
+					} else {
+						shareLink = link
+					}
+				}
 
-				reps_csv = append(reps_csv, rep)
-			} else if c.Bool(defs.OptionJSON) || c.Bool(defs.OptionJSONL) {
-				// print json if --json is given
 				var rep report.JSONReport
+				rep.SchemaVersion = defs.SchemaVersion
 				rep.Timestamp = time.Now()
 
-				rep.Ping = p
-				rep.Jitter = math.Round(jitter*100) / 100
-				rep.Download = downloadResult
-				rep.Upload = uploadResult
+				rep.PingMs = p
+				rep.JitterMs = math.Round(jitter*100) / 100
+				if loss := currentServer.PacketLoss; loss >= 0 {
+					rep.PacketLossPercent = &loss
+				}
+				rep.Download = report.NewTransfer(downloadResult)
+				rep.Upload = report.NewTransfer(uploadResult)
 				rep.Share = shareLink
+				rep.Proxy = redactURL(proxyUrl)
+				rep.Protocol = currentServer.Protocol
+				rep.Family = strings.ToLower(familyName(transport.network))
+				rep.DataUsedBytes = dataUsed
+				if currentServer.TLSVersion != "" {
+					rep.TLS = &report.TLS{Version: currentServer.TLSVersion, Cipher: currentServer.TLSCipher}
+				}
 
 				rep.Server.ID = currentServer.ID
 				rep.Server.Name = currentServer.Name
 				rep.Server.URL = u.String()
+				rep.Server.IP = currentServer.IP
 				rep.Server.Location = currentServer.Location
 				rep.Server.Country = currentServer.Country
+				rep.Server.Source = currentServer.Source
+
+				rep.Client = report.NewClient(ispInfo.RawISPInfo)
+
+				reportVersions[len(reps)] = transport.version
+				reps = append(reps, rep)
+			} else if isTLSError(upErr) {
+				// a certificate or pin mismatch isn't solved by trying again later
+				log.Errorf("Selected server %s (%s) failed the TLS checks: %s", currentServer.Name, u.Hostname(), upErr)
+				runErr = upErr
+			} else if transport.network != "" {
+				log.Infof("Selected server %s (%s) is not reachable over %s", currentServer.Name, u.Hostname(), familyName(transport.network))
+			} else if transport.version == "" {
+				log.Infof("Selected server %s (%s) is not responding at the moment, try again later", currentServer.Name, u.Hostname())
+			}
+		}
+
+		// use the default transport for the next server and telemetry
+		http.DefaultClient.Transport = baseTransport
+
+		// a forced HTTP version that can't be used fails the run instead of leaving the result out
+		for _, transport := range transports {
+			err, ok := versionErrs[transport.version]
+			if !ok || versionUsed[transport.version] {
+				continue
+			}
+			delete(versionErrs, transport.version)
+			log.Errorf("Server %s (%s) can't be tested with HTTP/%s: %s", currentServer.Name, u.Hostname(), transport.version, err)
+			runErr = fmt.Errorf("HTTP/%s can't be used with server %s", transport.version, currentServer.Name)
+		}
 
-				rep.Client = report.Client{ispInfo.RawISPInfo}
-				rep.Client.Readme = ""
+		// print a comparison of the HTTP and IP versions tested
+		if len(comparison) > 1 && !silent {
+			printComparison(comparison, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes))
+		}
 
-				reps_json = append(reps_json, rep)
+		// flag missing or slower IP versions in dual stack mode
+		if c.Bool(defs.OptionDualStack) {
+			for version, warning := range dualStackWarnings(transports, comparison) {
+				for i, v := range reportVersions {
+					if v == version {
+						reps[i].DualStackWarning = warning
+					}
+				}
+				if human {
+					log.Warnf("Warning: %s", warning)
+				}
+			}
+		}
+
+		for _, rep := range reps {
+			if err := w.WriteResult(rep); err != nil {
+				log.Errorf("Error writing results: %s", err)
+				return err
 			}
-		} else {
-			log.Infof("Selected server %s (%s) is not responding at the moment, try again later", currentServer.Name, u.Hostname())
 		}
 
 		//add a new line after each test if testing multiple servers
@@ -190,23 +366,11 @@ func doSpeedTest(c *cli.Context, servers []defs.Server, telemetryServer defs.Tel
 		}
 	}
 
-	// check for --csv or --json. the program prioritize the --csv before the --json. this is the same behavior as speedtest-cli
-	if c.Bool(defs.OptionCSV) {
-		var buf bytes.Buffer
-		if err := gocsv.MarshalWithoutHeaders(&reps_csv, &buf); err != nil {
-			log.Errorf("Error generating CSV report: %s", err)
-		} else {
-			log.Warn(buf.String())
-		}
-	} else if c.Bool(defs.OptionJSON) || c.Bool(defs.OptionJSONL) {
-		if b, err := json.Marshal(&reps_json); err != nil {
-			log.Errorf("Error generating JSON report: %s", err)
-		} else {
-			log.Warnf("%s", b)
-		}
+	if len(servers) > 1 || len(transports) > 1 {
+		log.Infof("Total data used: %s", units.Units{IEC: c.Bool(defs.OptionMebiBytes)}.Size(totalUsed))
 	}
 
-	return nil
+	return runErr
 }
 
 // sendTelemetry sends the telemetry result to server, if --share is given
@@ -319,22 +483,150 @@ func sendTelemetry(telemetryServer defs.TelemetryServer, ispInfo *defs.GetIPResu
 	}
 }
 
-func humanizeMbps(mbps float64, useMebi bool) string {
-	val := mbps / 8
-	var base float64 = 1000
-	if useMebi {
-		base = 1024
+// comparisonResult is the result of a test with one HTTP and IP version, for comparing the versions on the same server
+type comparisonResult struct {
+	version  string
+	network  string
+	protocol string
+	address  string
+	ping     float64
+	jitter   float64
+	// download and upload are in bit/s
+	download float64
+	upload   float64
+}
+
+// printComparison prints the results of the HTTP and IP versions tested on a server side by side
+func printComparison(results []comparisonResult, useBytes, useMebi bool) {
+	u := units.Units{Bytes: useBytes, IEC: useMebi}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Family\tAddress\tProtocol\tPing\tJitter\tDownload\tUpload")
+	for _, r := range results {
+		family := familyName(r.network)
+		if family == "" {
+			family = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.0f ms\t%.0f ms\t%s\t%s\n", family, r.address, r.protocol, r.ping, r.jitter, u.Rate(r.download), u.Rate(r.upload))
+	}
+	w.Flush()
+	log.Warn(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+// dualStackWarnings compares the IPv4 and IPv6 results for each HTTP version tested, and returns a warning per HTTP
+// version when one IP version is missing or significantly slower than the other
+func dualStackWarnings(transports []testTransport, results []comparisonResult) map[string]string {
+	ret := make(map[string]string)
+
+	var versions []string
+	seen := make(map[string]bool)
+	for _, t := range transports {
+		if !seen[t.version] {
+			seen[t.version] = true
+			versions = append(versions, t.version)
+		}
 	}
 
-	if val < 1 {
-		if kb := val * base; kb < 1 {
-			return fmt.Sprintf("%.2f bytes/s", kb*base)
-		} else {
-			return fmt.Sprintf("%.2f KB/s", kb)
+	for _, version := range versions {
+		var v4, v6 *comparisonResult
+		for i := range results {
+			if results[i].version != version {
+				continue
+			}
+			switch results[i].network {
+			case "ip4":
+				v4 = &results[i]
+			case "ip6":
+				v6 = &results[i]
+			}
 		}
-	} else if val > base {
-		return fmt.Sprintf("%.2f GB/s", val/base)
-	} else {
-		return fmt.Sprintf("%.2f MB/s", val)
+
+		var prefix string
+		if version != "" {
+			prefix = "HTTP/" + version + ": "
+		}
+
+		switch {
+		case v4 == nil && v6 == nil:
+			ret[version] = prefix + "both IPv4 and IPv6 tests failed"
+		case v4 == nil:
+			ret[version] = prefix + "IPv4 is not available"
+		case v6 == nil:
+			ret[version] = prefix + "IPv6 is not available"
+		default:
+			var slower []string
+			if msg := compareFamilies("IPv6", "IPv4", v6, v4); msg != "" {
+				slower = append(slower, msg)
+			}
+			if msg := compareFamilies("IPv4", "IPv6", v4, v6); msg != "" {
+				slower = append(slower, msg)
+			}
+			if len(slower) > 0 {
+				ret[version] = prefix + strings.Join(slower, ", ")
+			}
+		}
+	}
+
+	return ret
+}
+
+// compareFamilies describes how the result `a` is significantly slower than `b`, or returns an empty string
+func compareFamilies(nameA, nameB string, a, b *comparisonResult) string {
+	var reasons []string
+	if a.ping-b.ping > dualStackPingDiff && a.ping > b.ping*dualStackPingRatio {
+		reasons = append(reasons, fmt.Sprintf("ping %.0f ms vs %.0f ms", a.ping, b.ping))
+	}
+	if b.download > 0 && a.download < b.download*dualStackSlowRatio {
+		reasons = append(reasons, fmt.Sprintf("download %.0f%% of %s", a.download/b.download*100, nameB))
+	}
+	if b.upload > 0 && a.upload < b.upload*dualStackSlowRatio {
+		reasons = append(reasons, fmt.Sprintf("upload %.0f%% of %s", a.upload/b.upload*100, nameB))
+	}
+
+	if len(reasons) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s is significantly slower than %s (%s)", nameA, nameB, strings.Join(reasons, ", "))
+}
+
+// transferCap returns the byte cap of the next transfer from --max-bytes and what is left of --max-total-bytes, with 0
+// for no cap. It returns false if the total cap is already reached
+func transferCap(maxBytes, maxTotalBytes, used int64) (int64, bool) {
+	if maxTotalBytes == 0 {
+		return maxBytes, true
+	}
+
+	remaining := maxTotalBytes - used
+	if remaining <= 0 {
+		return 0, false
+	}
+	if maxBytes == 0 || remaining < maxBytes {
+		return remaining, true
+	}
+	return maxBytes, true
+}
+
+// logInterfaceCounters logs the wire overhead of a transfer, and warns about errors or drops on the interface unless the
+// output is machine readable, in which case the warning is part of the report
+func logInterfaceCounters(direction string, counters *defs.InterfaceCounters, warn bool) {
+	if counters == nil {
+		return
+	}
+
+	log.Infof("Interface %s: %d bytes on the wire during the %s, %.2f%% overhead", counters.Name, counters.WireBytes, direction, counters.OverheadPercent)
+	if counters.Warning != "" && warn {
+		log.Warnf("Warning: %s during the %s", counters.Warning, direction)
+	}
+}
+
+// familyName returns the display name of the IP version used by a network, or an empty string for any version
+func familyName(network string) string {
+	switch network {
+	case "ip4":
+		return "IPv4"
+	case "ip6":
+		return "IPv6"
+	default:
+		return ""
 	}
 }
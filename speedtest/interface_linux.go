@@ -0,0 +1,64 @@
+package speedtest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// netnsDir is where `ip netns` keeps the named network namespaces
+	netnsDir = "/var/run/netns"
+
+	// netnsEnv marks the process re-executed inside the network namespace, so that it doesn't enter it again
+	netnsEnv = "LIBRESPEED_CLI_NETNS"
+)
+
+// bindToDevice returns a socket control function binding sockets to the network interface with SO_BINDTODEVICE
+func bindToDevice(iface string) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		if err := c.Control(func(fd uintptr) {
+			bindErr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+		}); err != nil {
+			return err
+		}
+		if bindErr != nil {
+			return fmt.Errorf("failed to bind to interface %s: %w", iface, bindErr)
+		}
+		return nil
+	}, nil
+}
+
+// enterNetns runs the program inside the named network namespace. Since a namespace can only be entered by a single
+// thread, the thread entering it re-executes the program, which then runs entirely in the namespace. It only returns
+// on errors, or in the re-executed program
+func enterNetns(name string) error {
+	if os.Getenv(netnsEnv) == name {
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(netnsDir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// the thread is never unlocked, as it is replaced by exec or left in an unknown namespace on errors
+	runtime.LockOSThread()
+	if err := unix.Setns(int(f.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("failed to enter network namespace %s: %w", name, err)
+	}
+
+	env := append(os.Environ(), netnsEnv+"="+name)
+	return syscall.Exec(exe, os.Args, env)
+}
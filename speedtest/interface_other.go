@@ -0,0 +1,18 @@
+//go:build !linux
+
+package speedtest
+
+import (
+	"errors"
+	"syscall"
+)
+
+// bindToDevice is only supported on Linux
+func bindToDevice(iface string) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("binding to a network interface is only supported on Linux")
+}
+
+// enterNetns is only supported on Linux
+func enterNetns(name string) error {
+	return errors.New("network namespaces are only supported on Linux")
+}
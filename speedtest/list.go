@@ -0,0 +1,262 @@
+package speedtest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocarina/gocsv"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"librespeed-cli/defs"
+	"librespeed-cli/report"
+)
+
+const (
+	sortByID       = "id"
+	sortByName     = "name"
+	sortByDistance = "distance"
+	sortByPing     = "ping"
+)
+
+// distanceRegex matches the distance appended by the backend to the processed string of getIP, e.g. "(1,230 km)" or
+// "(<20 km)"
+var distanceRegex = regexp.MustCompile(`\((<)?([\d,.]+) ?(km|mi|NM)\)`)
+
+// listServers prints the server list for --list in the output format, applying the filter and sort options
+func listServers(c *cli.Context, servers []defs.Server, network, format string) error {
+	sortBy := strings.ToLower(c.String(defs.OptionSort))
+	switch sortBy {
+	case "", sortByID, sortByName, sortByDistance:
+	case sortByPing:
+		if !c.Bool(defs.OptionPing) {
+			return errors.New("sorting by ping requires --" + defs.OptionPing)
+		}
+	default:
+		log.Errorf("Unsupported sort key: %s", sortBy)
+		return errors.New("invalid sort key")
+	}
+
+	servers = filterServerList(servers, c.String(defs.OptionFilterName), c.String(defs.OptionFilterCountry), c.String(defs.OptionFilterSponsor))
+
+	entries := make([]report.ServerListEntry, len(servers))
+	distances := make([]float64, len(servers))
+	for idx, svr := range servers {
+		entries[idx] = report.ServerListEntry{
+			ID:       svr.ID,
+			Name:     svr.Name,
+			URL:      svr.Server,
+			Location: svr.Location,
+			Country:  svr.Country,
+			Sponsor:  svr.Sponsor(),
+			Source:   svr.Source,
+		}
+		distances[idx] = -1
+	}
+
+	// probe the servers if ping or distance is needed
+	probePing := c.Bool(defs.OptionPing)
+	if probePing || sortBy == sortByDistance {
+		log.Infof("Probing %d servers", len(servers))
+
+		opts := probeOptions{
+			srcIp:   c.String(defs.OptionSource),
+			iface:   c.String(defs.OptionInterface),
+			network: network,
+			noICMP:  c.Bool(defs.OptionNoICMP),
+			ping:    probePing,
+
+			workers:       c.Int(defs.OptionSelectWorkers),
+			serverTimeout: time.Duration(c.Int(defs.OptionServerTimeout)) * time.Second,
+			timeout:       time.Duration(c.Int(defs.OptionSelectTimeout)) * time.Second,
+		}
+		if sortBy == sortByDistance {
+			opts.distanceUnit = c.String(defs.OptionDistance)
+		}
+
+		for idx, result := range probeServers(servers, opts) {
+			if probePing {
+				if result.Up {
+					entries[idx].Status = "up"
+				} else {
+					entries[idx].Status = "down"
+				}
+				if result.Pinged {
+					ping := result.Ping
+					entries[idx].PingMs = &ping
+				}
+			}
+			if result.DistanceText != "" {
+				entries[idx].Distance = result.DistanceText
+				distances[idx] = result.Distance
+			}
+		}
+	}
+
+	sortServerList(entries, distances, sortBy)
+
+	out, err := openOutput(c, false)
+	if err != nil {
+		log.Errorf("Error opening output: %s", err)
+		return err
+	}
+	defer out.Discard()
+
+	var buf bytes.Buffer
+	switch format {
+	case report.FormatCSV, report.FormatTSV:
+		w := csv.NewWriter(&buf)
+		if format == report.FormatTSV {
+			w.Comma = '\t'
+		} else {
+			w.Comma, _ = utf8.DecodeRuneInString(c.String(defs.OptionCSVDelimiter))
+		}
+		if err := gocsv.MarshalCSV(&entries, gocsv.NewSafeCSVWriter(w)); err != nil {
+			log.Errorf("Error generating CSV server list: %s", err)
+			return err
+		}
+	case report.FormatJSONL:
+		for _, entry := range entries {
+			b, err := json.Marshal(&entry)
+			if err != nil {
+				log.Errorf("Error generating JSON server list: %s", err)
+				return err
+			}
+			buf.Write(b)
+			buf.WriteByte('\n')
+		}
+	case report.FormatJSON:
+		b, err := json.Marshal(&entries)
+		if err != nil {
+			log.Errorf("Error generating JSON server list: %s", err)
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	default:
+		buf.WriteString(formatServerTable(entries, probePing, sortBy == sortByDistance))
+	}
+
+	if _, err := buf.WriteTo(out); err != nil {
+		log.Errorf("Error writing server list: %s", err)
+		return err
+	}
+	return out.Close()
+}
+
+// filterServerList returns the servers matching all the given filters. Filters are case-insensitive substrings, and
+// empty filters match everything
+func filterServerList(servers []defs.Server, name, country, sponsor string) []defs.Server {
+	match := func(val, filter string) bool {
+		return filter == "" || strings.Contains(strings.ToLower(val), strings.ToLower(filter))
+	}
+
+	var ret []defs.Server
+	for _, svr := range servers {
+		if match(svr.Name, name) && match(svr.Country, country) && match(svr.Sponsor(), sponsor) {
+			ret = append(ret, svr)
+		}
+	}
+	return ret
+}
+
+// sortServerList sorts the list entries in place. Servers without a measured ping or distance are put at the end
+func sortServerList(entries []report.ServerListEntry, distances []float64, sortBy string) {
+	if sortBy == "" {
+		return
+	}
+
+	idx := make([]int, len(entries))
+	for i := range idx {
+		idx[i] = i
+	}
+
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := entries[idx[i]], entries[idx[j]]
+		switch sortBy {
+		case sortByName:
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		case sortByDistance:
+			da, db := distances[idx[i]], distances[idx[j]]
+			if da < 0 || db < 0 {
+				return da >= 0
+			}
+			return da < db
+		case sortByPing:
+			if a.PingMs == nil || b.PingMs == nil {
+				return a.PingMs != nil
+			}
+			return *a.PingMs < *b.PingMs
+		default:
+			return a.ID < b.ID
+		}
+	})
+
+	sorted := make([]report.ServerListEntry, len(entries))
+	for i, j := range idx {
+		sorted[i] = entries[j]
+	}
+	copy(entries, sorted)
+}
+
+// formatServerTable formats the list entries as an aligned table
+func formatServerTable(entries []report.ServerListEntry, withPing, withDistance bool) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	header := "ID\tNAME\tURL\tLOCATION\tCOUNTRY\tSPONSOR\tSOURCE"
+	if withPing {
+		header += "\tSTATUS\tPING"
+	}
+	if withDistance {
+		header += "\tDISTANCE"
+	}
+	fmt.Fprintln(w, header)
+
+	for _, entry := range entries {
+		line := fmt.Sprintf("%d\t%s\t%s\t%s\t%s\t%s\t%s", entry.ID, entry.Name, entry.URL, entry.Location, entry.Country, entry.Sponsor, entry.Source)
+		if withPing {
+			ping := "-"
+			if entry.PingMs != nil {
+				ping = fmt.Sprintf("%.0f ms", *entry.PingMs)
+			}
+			line += fmt.Sprintf("\t%s\t%s", entry.Status, ping)
+		}
+		if withDistance {
+			distance := "-"
+			if entry.Distance != "" {
+				distance = entry.Distance
+			}
+			line += "\t" + distance
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	w.Flush()
+	return buf.String()
+}
+
+// parseDistance extracts the distance from the processed string returned by the backend's getIP endpoint. It returns -1
+// if no distance is found
+func parseDistance(processed string) (float64, string) {
+	m := distanceRegex.FindStringSubmatch(processed)
+	if m == nil {
+		return -1, ""
+	}
+
+	val, err := strconv.ParseFloat(strings.ReplaceAll(m[2], ",", ""), 64)
+	if err != nil {
+		return -1, ""
+	}
+	return val, strings.Trim(m[0], "()")
+}
@@ -0,0 +1,306 @@
+package speedtest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"librespeed-cli/defs"
+	"librespeed-cli/report"
+	"librespeed-cli/sink"
+	"librespeed-cli/tui"
+	"librespeed-cli/units"
+)
+
+// formatShorthands are the flags selecting the output format of the same name
+var formatShorthands = []string{defs.OptionSimple, defs.OptionJSON, defs.OptionJSONL, defs.OptionCSV}
+
+// outputFormat returns the output format given by --format or its shorthand flags, and an error if they conflict
+func outputFormat(c *cli.Context) (string, error) {
+	format := c.String(defs.OptionFormat)
+	flag := "--" + defs.OptionFormat + " " + format
+	for _, name := range formatShorthands {
+		if !c.Bool(name) {
+			continue
+		}
+		if format != "" && format != name {
+			return "", fmt.Errorf("conflicting output formats: %s and --%s", flag, name)
+		}
+		format = name
+		flag = "--" + name
+	}
+
+	if format == "" {
+		return report.FormatText, nil
+	}
+	return format, report.CheckFormat(format)
+}
+
+// writeResults writes the results of `run` in the output format to the output. The sinks connect with the transport
+// options of the tests if given
+func writeResults(c *cli.Context, format string, opts *transportOptions, run func(w report.Writer) error) error {
+	// the textfile collector may read the file at any time, it must be replaced at once
+	out, err := openOutput(c, format == report.FormatProm)
+	if err != nil {
+		log.Errorf("Error opening output: %s", err)
+		return err
+	}
+	defer out.Discard()
+
+	// appended files only get the CSV header when new
+	header := c.Bool(defs.OptionCSVHeader) || c.Bool(defs.OptionCSVWithHeader)
+	if c.Bool(defs.OptionAppend) {
+		header = out.isNew
+	}
+	w, err := newOutput(c, format, out, header)
+	if err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
+	if useTUI(c) {
+		ui := tui.New(os.Stdout, w, units.Units{Bytes: c.Bool(defs.OptionBytes), IEC: c.Bool(defs.OptionMebiBytes)})
+		// the terminal is restored if the tests fail
+		defer ui.Stop()
+		w = ui
+	}
+	if urls := c.StringSlice(defs.OptionSink); len(urls) > 0 {
+		sinks, err := newSinks(c, urls, opts)
+		if err != nil {
+			log.Errorf("%s", err)
+			return err
+		}
+		w = report.MultiWriter(w, sinks)
+	}
+
+	if err := w.Begin(); err != nil {
+		log.Errorf("Error writing results: %s", err)
+		return err
+	}
+	// the results measured before a failure are still written and sent to the sinks, and the JSONL stream ends with
+	// the summary
+	if err := func() (err error) {
+		defer func() {
+			if endErr := w.End(); endErr != nil {
+				log.Errorf("Error writing results: %s", endErr)
+				if err == nil {
+					err = endErr
+				}
+			}
+		}()
+		return run(w)
+	}(); err != nil {
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		log.Errorf("Error closing output: %s", err)
+		return err
+	}
+	return nil
+}
+
+// newOutput returns the writer of the output format with the options given, writing to `w`, with the CSV header if
+// `header` is set
+func newOutput(c *cli.Context, format string, w io.Writer, header bool) (report.Writer, error) {
+	delimiter, _ := utf8.DecodeRuneInString(c.String(defs.OptionCSVDelimiter))
+	return report.NewWriter(format, w, report.Options{
+		UseBytes:  c.Bool(defs.OptionBytes),
+		UseMebi:   c.Bool(defs.OptionMebiBytes),
+		Delimiter: delimiter,
+		Header:    header,
+		Fields:    csvFields(c),
+	})
+}
+
+// csvFields returns the fields given by --csv-fields, each value being a comma separated list
+func csvFields(c *cli.Context) []string {
+	var fields []string
+	for _, value := range c.StringSlice(defs.OptionCSVFields) {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// checkCSV checks the CSV options, before running any test
+func checkCSV(c *cli.Context, format string) error {
+	if err := report.CheckCSVDelimiter(c.String(defs.OptionCSVDelimiter)); err != nil {
+		return err
+	}
+	if err := report.CheckCSVFields(csvFields(c)); err != nil {
+		return err
+	}
+	if c.Bool(defs.OptionAppend) {
+		if c.String(defs.OptionOutput) == "" {
+			return fmt.Errorf("--%s requires --%s", defs.OptionAppend, defs.OptionOutput)
+		}
+		if format != report.FormatCSV && format != report.FormatTSV {
+			return fmt.Errorf("--%s is only supported by the csv and tsv formats", defs.OptionAppend)
+		}
+	}
+	return nil
+}
+
+// checkTUI checks that the live view given by --tui can be shown with the output format, before running any test
+func checkTUI(c *cli.Context, format string) error {
+	if !c.Bool(defs.OptionTUI) {
+		return nil
+	}
+	if format != report.FormatText || c.String(defs.OptionOutput) != "" {
+		return fmt.Errorf("--%s is only supported by the text format on stdout", defs.OptionTUI)
+	}
+	if c.Bool(defs.OptionQuiet) {
+		return fmt.Errorf("--%s and --%s cannot be used together", defs.OptionTUI, defs.OptionQuiet)
+	}
+	if !tui.IsTerminal(os.Stdout) {
+		log.Warnf("Ignoring --%s, stdout is not a terminal", defs.OptionTUI)
+	}
+	return nil
+}
+
+// useTUI tells whether the live view is shown, when --tui is given and stdout is a terminal
+func useTUI(c *cli.Context) bool {
+	return c.Bool(defs.OptionTUI) && tui.IsTerminal(os.Stdout)
+}
+
+// progressEvents tells whether the progress of the tests is sent as events, to the JSONL output or the live view
+func progressEvents(c *cli.Context, format string) bool {
+	return format == report.FormatJSONL || useTUI(c)
+}
+
+// checkSinks checks the URLs given by --sink, before running any test
+func checkSinks(c *cli.Context) error {
+	for _, rawURL := range c.StringSlice(defs.OptionSink) {
+		if _, err := sink.New(rawURL, sink.Options{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newSinks returns the writer sending the results to the sinks given by --sink, connecting with the transport options
+// of the tests if given
+func newSinks(c *cli.Context, urls []string, opts *transportOptions) (report.Writer, error) {
+	spool := c.String(defs.OptionSinkSpool)
+	if spool == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("cannot find a spool directory for the sinks, use --%s: %w", defs.OptionSinkSpool, err)
+		}
+		spool = filepath.Join(dir, "librespeed-cli", "spool")
+	}
+
+	sinkOpts := sink.Options{
+		Timeout:  time.Duration(c.Int(defs.OptionSinkTimeout)) * time.Second,
+		Retries:  c.Int(defs.OptionSinkRetries),
+		SpoolDir: spool,
+	}
+	// the sinks use the proxy, interface and TLS settings of the tests, but not the keys pinned for the test servers
+	if opts != nil {
+		netOpts := *opts
+		netOpts.pins = nil
+		netOpts.tlsConfig = opts.tlsConfig.Clone()
+		netOpts.tlsConfig.VerifyConnection = nil
+
+		sinkOpts.Transport = newTransport(netOpts)
+		sinkOpts.Dial = newSinkDialer(netOpts)
+		sinkOpts.TLSConfig = netOpts.tlsConfig
+	}
+	return sink.NewWriter(urls, sinkOpts)
+}
+
+// openOutput returns the file given by --output, or stdout. An atomic file is written to a temporary file, which
+// replaces the file given once closed. With --append, the results are added to the end of the file
+func openOutput(c *cli.Context, atomic bool) (*outputFile, error) {
+	path := c.String(defs.OptionOutput)
+	if path == "" {
+		return &outputFile{Writer: os.Stdout, isNew: true}, nil
+	}
+
+	if c.Bool(defs.OptionAppend) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &outputFile{Writer: f, file: f, isNew: info.Size() == 0}, nil
+	}
+
+	if !atomic {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return &outputFile{Writer: f, file: f, isNew: true}, nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	// readable by other users like a file created, e.g. node_exporter
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &outputFile{Writer: f, file: f, path: path, isNew: true}, nil
+}
+
+// outputFile is the output of the results
+type outputFile struct {
+	io.Writer
+	file *os.File
+	// path is the file replaced by the temporary file on Close, for atomic files
+	path string
+	// isNew is set unless results are appended to a file that isn't empty
+	isNew  bool
+	closed bool
+}
+
+// Close closes the file, and replaces the file given by the temporary file if atomic
+func (o *outputFile) Close() error {
+	if o.file == nil || o.closed {
+		return nil
+	}
+	o.closed = true
+
+	err := o.file.Close()
+	if o.path == "" {
+		return err
+	}
+	if err == nil {
+		err = os.Rename(o.file.Name(), o.path)
+	}
+	if err != nil {
+		os.Remove(o.file.Name())
+	}
+	return err
+}
+
+// Discard closes the file unless already closed, leaving the file given untouched if atomic
+func (o *outputFile) Discard() {
+	if o.file == nil || o.closed {
+		return
+	}
+	o.closed = true
+
+	o.file.Close()
+	if o.path != "" {
+		os.Remove(o.file.Name())
+	}
+}
@@ -2,7 +2,6 @@ package speedtest
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,14 +12,15 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/gocarina/gocsv"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
 	"librespeed-cli/defs"
 	"librespeed-cli/report"
+	"librespeed-cli/tui"
 )
 
 const (
@@ -31,57 +31,131 @@ const (
 	defaultTelemetryServer = "https://librespeed.org"
 	defaultTelemetryPath   = "/results/telemetry.php"
 	defaultTelemetryShare  = "/results/"
+
+	// defaultPingWorkers is the number of concurrent workers used to probe servers
+	defaultPingWorkers = 10
+
+	// serverIDNamespace is the ID offset applied per source when IDs from multiple server lists collide
+	serverIDNamespace = 100000
 )
 
+// PingJob is a server to probe, pointing into the server list so the addresses found while probing are kept
 type PingJob struct {
 	Index  int
-	Server defs.Server
+	Server *defs.Server
 }
 
 type PingResult struct {
-	Index int
-	Ping  float64
+	Index        int
+	Up           bool
+	Pinged       bool
+	TLSFailed    bool
+	Ping         float64
+	Distance     float64
+	DistanceText string
+}
+
+// probeOptions controls what is measured by the ping workers
+type probeOptions struct {
+	srcIp        string
+	iface        string
+	network      string
+	noICMP       bool
+	ping         bool
+	distanceUnit string
+
+	// workers is the size of the worker pool
+	workers int
+	// serverTimeout is the deadline for probing a single server
+	serverTimeout time.Duration
+	// timeout is the deadline for probing all servers
+	timeout time.Duration
+	// progress sends selection progress events, in JSONL mode or to the live view
+	progress bool
 }
 
 // SpeedTest is the actual main function that handles the speed test(s)
 func SpeedTest(c *cli.Context) error {
+	// print help and version before checking the other options, which they ignore
+	if c.Bool(defs.OptionHelp) {
+		return cli.ShowAppHelp(c)
+	}
+
+	// print version, on stdout like the help
+	if c.Bool(defs.OptionVersion) {
+		w := c.App.Writer
+		fmt.Fprintf(w, "%s %s (built on %s)\n", defs.ProgName, defs.ProgVersion, defs.BuildDate)
+		fmt.Fprintln(w, "https://github.com/librespeed/speedtest-cli")
+		fmt.Fprintln(w, "Licensed under GNU Lesser General Public License v3.0")
+		fmt.Fprintln(w, "LibreSpeed\tCopyright (C) 2016-2020 Federico Dossena")
+		fmt.Fprintln(w, "librespeed-cli\tCopyright (C) 2020 Maddie Zhan")
+		fmt.Fprintln(w, "librespeed.org\tCopyright (C)")
+		return nil
+	}
+
+	format, err := outputFormat(c)
+	if err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
+	if err := checkSinks(c); err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
+
+	if err := checkCSV(c, format); err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
+	if err := checkTUI(c, format); err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
+
+	// the progress is shown with spinners and errors in color on a terminal, unless disabled by --no-color or NO_COLOR
+	terminal := tui.IsTerminal(os.Stderr)
+	defs.SetProgressTerminal(terminal)
+	log.SetFormatter(&defs.NoFormatter{Color: terminal && !c.Bool(defs.OptionNoColor) && os.Getenv("NO_COLOR") == ""})
+
 	// check for suppressed output flags
 	var silent bool
-	if c.Bool(defs.OptionSimple) || c.Bool(defs.OptionJSON) || c.Bool(defs.OptionJSONL) || c.Bool(defs.OptionCSV) {
+	if (format != report.FormatText && c.String(defs.OptionOutput) == "") || c.Bool(defs.OptionQuiet) {
 		log.SetLevel(log.WarnLevel)
 		silent = true
 	}
+	// the live view shows the progress instead of the spinners
+	if useTUI(c) {
+		silent = true
+	}
 
 	// check for debug flag
 	if c.Bool(defs.OptionDebug) {
 		log.SetLevel(log.DebugLevel)
 	}
 
-	// print help
-	if c.Bool(defs.OptionHelp) {
-		return cli.ShowAppHelp(c)
-	}
-
-	// print version
-	if c.Bool(defs.OptionVersion) {
-		log.Warnf("%s %s (built on %s)", defs.ProgName, defs.ProgVersion, defs.BuildDate)
-		log.Warn("https://github.com/librespeed/speedtest-cli")
-		log.Warn("Licensed under GNU Lesser General Public License v3.0")
-		log.Warn("LibreSpeed\tCopyright (C) 2016-2020 Federico Dossena")
-		log.Warn("librespeed-cli\tCopyright (C) 2020 Maddie Zhan")
-		log.Warn("librespeed.org\tCopyright (C)")
-		return nil
+	// run in the network namespace if given, re-executing the program inside it
+	if netns := c.String(defs.OptionNetns); netns != "" {
+		if err := enterNetns(netns); err != nil {
+			log.Errorf("Error entering network namespace %s: %s", netns, err)
+			return err
+		}
+		log.Debugf("Running in network namespace %s", netns)
 	}
 
-	// set CSV delimiter
-	gocsv.TagSeparator = c.String(defs.OptionCSVDelimiter)
-
 	// if --csv-header is given, print the header and exit (same behavior speedtest-cli)
 	if c.Bool(defs.OptionCSVHeader) {
-		var rep []report.CSVReport
-		b, _ := gocsv.MarshalBytes(&rep)
-		log.Warnf("%s", b)
-		return nil
+		switch format {
+		case report.FormatText:
+			format = report.FormatCSV
+		case report.FormatCSV, report.FormatTSV:
+		default:
+			err := fmt.Errorf("--%s is not supported by the %s format", defs.OptionCSVHeader, format)
+			log.Errorf("%s", err)
+			return err
+		}
+		return writeResults(c, format, nil, func(report.Writer) error {
+			return nil
+		})
 	}
 
 	// read telemetry settings if --share or any --telemetry option is given
@@ -99,7 +173,7 @@ func SpeedTest(c *cli.Context) error {
 				return err
 			}
 			if err := json.Unmarshal(b, &telemetryServer); err != nil {
-				log.Errorf("Error parsing %s: %s", err)
+				log.Errorf("Error parsing %s: %s", telemetryJSON, err)
 				return err
 			}
 		}
@@ -143,6 +217,16 @@ func SpeedTest(c *cli.Context) error {
 	forceIPv4 := c.Bool(defs.OptionIPv4)
 	forceIPv6 := c.Bool(defs.OptionIPv6)
 
+	// dual stack mode tests both IP versions, which can't be combined with forcing one or binding a source address
+	var networks []string
+	if c.Bool(defs.OptionDualStack) {
+		if forceIPv4 || forceIPv6 || c.String(defs.OptionSource) != "" {
+			log.Errorf("--%s can't be used with --%s, --%s or --%s", defs.OptionDualStack, defs.OptionIPv4, defs.OptionIPv6, defs.OptionSource)
+			return errors.New("incompatible options")
+		}
+		networks = []string{"ip4", "ip6"}
+	}
+
 	var network string
 	switch {
 	case forceIPv4:
@@ -153,206 +237,405 @@ func SpeedTest(c *cli.Context) error {
 		network = "ip"
 	}
 
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.Bool(defs.OptionSkipCertVerify)}
-
-	// bind to source IP address if given, or if ipv4/ipv6 is forced
-	if src := c.String(defs.OptionSource); src != "" || (forceIPv4 || forceIPv6) {
-		var localTCPAddr *net.TCPAddr
-		if src != "" {
-			// first we parse the IP to see if it's valid
-			addr, err := net.ResolveIPAddr(network, src)
-			if err != nil {
-				if strings.Contains(err.Error(), "no suitable address") {
-					if forceIPv6 {
-						log.Errorf("Address %s is not a valid IPv6 address", src)
-					} else {
-						log.Errorf("Address %s is not a valid IPv4 address", src)
-					}
+	// bind to source IP address if given
+	var localTCPAddr *net.TCPAddr
+	if src := c.String(defs.OptionSource); src != "" {
+		// first we parse the IP to see if it's valid
+		addr, err := net.ResolveIPAddr(network, src)
+		if err != nil {
+			if strings.Contains(err.Error(), "no suitable address") {
+				if forceIPv6 {
+					log.Errorf("Address %s is not a valid IPv6 address", src)
 				} else {
-					log.Errorf("Error parsing source IP: %s", err)
+					log.Errorf("Address %s is not a valid IPv4 address", src)
 				}
-				return err
+			} else {
+				log.Errorf("Error parsing source IP: %s", err)
 			}
-
-			log.Debugf("Using %s as source IP", src)
-			localTCPAddr = &net.TCPAddr{IP: addr.IP}
+			return err
 		}
 
-		var dialContext func(context.Context, string, string) (net.Conn, error)
-		defaultDialer := &net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}
+		log.Debugf("Using %s as source IP", src)
+		localTCPAddr = &net.TCPAddr{IP: addr.IP}
+	}
 
-		if localTCPAddr != nil {
-			defaultDialer.LocalAddr = localTCPAddr
+	// bind to network interface if given
+	var control func(network, address string, c syscall.RawConn) error
+	if iface := c.String(defs.OptionInterface); iface != "" {
+		if _, err := net.InterfaceByName(iface); err != nil {
+			log.Errorf("Invalid interface %s: %s", iface, err)
+			return err
 		}
 
-		switch {
-		case forceIPv4:
-			dialContext = func(ctx context.Context, network, address string) (conn net.Conn, err error) {
-				return defaultDialer.DialContext(ctx, "tcp4", address)
-			}
-		case forceIPv6:
-			dialContext = func(ctx context.Context, network, address string) (conn net.Conn, err error) {
-				return defaultDialer.DialContext(ctx, "tcp6", address)
-			}
-		default:
-			dialContext = defaultDialer.DialContext
+		var err error
+		if control, err = bindToDevice(iface); err != nil {
+			log.Errorf("Error binding to interface %s: %s", iface, err)
+			return err
 		}
-
-		// set default HTTP client's Transport to the one that binds the source address
-		// this is modified from http.DefaultTransport
-		transport.DialContext = dialContext
+		log.Debugf("Using %s as network interface", iface)
 	}
 
-	http.DefaultClient.Transport = transport
+	proxy, err := parseProxy(c.String(defs.OptionProxy))
+	if err != nil {
+		log.Errorf("Invalid proxy %s: %s", c.String(defs.OptionProxy), err)
+		return err
+	}
 
-	// load server list
-	var servers []defs.Server
-	var err error
-	if str := c.String(defs.OptionLocalJSON); str != "" {
-		switch str {
-		case "-":
-			// load server list from stdin
-			log.Info("Using local JSON server list from stdin")
-			servers, err = getLocalServersReader(c.Bool(defs.OptionSecure), os.Stdin, c.IntSlice(defs.OptionExclude), c.IntSlice(defs.OptionServer), !c.Bool(defs.OptionList))
-		default:
-			// load server list from local JSON file
-			log.Infof("Using local JSON server list: %s", str)
-			servers, err = getLocalServers(c.Bool(defs.OptionSecure), str, c.IntSlice(defs.OptionExclude), c.IntSlice(defs.OptionServer), !c.Bool(defs.OptionList))
-		}
-	} else {
-		// fetch the server list JSON and parse it into the `servers` array
-		serverUrl := serverListUrl
-		if str := c.String(defs.OptionServerJSON); str != "" {
-			serverUrl = str
+	var resolver *net.Resolver
+	if dnsServer := c.String(defs.OptionDNSServer); dnsServer != "" {
+		resolver, err = newResolver(dnsServer, control)
+		if err != nil {
+			log.Errorf("Invalid DNS server %s: %s", dnsServer, err)
+			return err
 		}
-		log.Infof("Retrieving server list from %s", serverUrl)
+		log.Debugf("Using %s as DNS server", dnsServer)
+	} else if control != nil {
+		// DNS queries also go through the interface
+		resolver = newBoundResolver(control)
+	}
 
-		servers, err = getServerList(c.Bool(defs.OptionSecure), serverUrl, c.IntSlice(defs.OptionExclude), c.IntSlice(defs.OptionServer), !c.Bool(defs.OptionList))
+	overrides, err := parseResolveOverrides(c.StringSlice(defs.OptionResolve))
+	if err != nil {
+		log.Errorf("%s", err)
+		return err
+	}
 
-		if err != nil {
-			log.Info("Retry with /.well-known/librespeed")
-			servers, err = getServerList(c.Bool(defs.OptionSecure), serverUrl+"/.well-known/librespeed", c.IntSlice(defs.OptionExclude), c.IntSlice(defs.OptionServer), !c.Bool(defs.OptionList))
-		}
+	// the public keys pinned in the server list are set once the list is loaded
+	pins := &keyPins{}
+	tlsConfig, err := newTLSConfig(c.Bool(defs.OptionSkipCertVerify), c.String(defs.OptionCACert), c.String(defs.OptionClientCert), c.String(defs.OptionClientKey), pins)
+	if err != nil {
+		log.Errorf("Error setting up TLS: %s", err)
+		return err
 	}
+
+	// set default HTTP client's Transport to the one that binds the source address, forces the IP version and uses
+	// the proxy, so that the server list, tests and telemetry all go through it
+	transportOpts := transportOptions{
+		network:    network,
+		source:     localTCPAddr,
+		tlsConfig:  tlsConfig,
+		proxy:      proxy,
+		proxyGiven: proxy != nil && c.String(defs.OptionProxy) != "",
+		resolver:   resolver,
+		overrides:  overrides,
+		control:    control,
+		pins:       pins,
+	}
+	transport := newTransport(transportOpts)
+
+	// add the custom headers to all requests, the ones of each server are set once the list is loaded
+	headers, err := newRequestHeaders(c.StringSlice(defs.OptionHeader), c.String(defs.OptionAuthBearer), c.String(defs.OptionAuthBasic))
 	if err != nil {
-		log.Errorf("Error when fetching server list: %s", err)
+		log.Errorf("%s", err)
+		return err
+	}
+	http.DefaultClient.Transport = headers.wrap(transport)
+
+	// the tests can be forced to use specific HTTP versions, and run over both IP versions
+	transports, err := newTestTransports(c.StringSlice(defs.OptionHTTPVersion), networks, transportOpts, transport)
+	if err != nil {
+		log.Errorf("Error setting up HTTP version: %s", err)
 		return err
 	}
+	defer closeTestTransports(transports)
+	for i := range transports {
+		transports[i].rt = headers.wrap(transports[i].rt)
+	}
 
 	// if --list is given, list all the servers fetched and exit
 	if c.Bool(defs.OptionList) {
-		for _, svr := range servers {
-			var sponsorMsg string
-			if svr.Sponsor() != "" {
-				sponsorMsg = fmt.Sprintf(" [Sponsor: %s]", svr.Sponsor())
-			}
-			log.Warnf("%d: %s (%s) %s", svr.ID, svr.Name, svr.Server, sponsorMsg)
+		servers, err := loadServerList(c, pins, headers)
+		if err != nil {
+			return err
 		}
-		return nil
+		return listServers(c, servers, network, format)
 	}
 
-	// if --server is given, do speed tests with all of them
-	if len(c.IntSlice(defs.OptionServer)) > 0 {
-		return doSpeedTest(c, servers, telemetryServer, network, silent)
-	} else {
+	// the server list is loaded once the results are written, so that its errors are sent as events
+	return writeResults(c, format, &transportOpts, func(w report.Writer) error {
+		servers, err := loadServerList(c, pins, headers)
+		if err != nil {
+			return err
+		}
+
+		// if --server is given, do speed tests with all of them
+		if len(c.IntSlice(defs.OptionServer)) > 0 {
+			return doSpeedTest(c, servers, telemetryServer, network, format, silent, transports, w)
+		}
+
 		// else select the fastest server from the list
 		log.Info("Selecting the fastest server based on ping")
 
-		var wg sync.WaitGroup
-		jobs := make(chan PingJob, len(servers))
-		results := make(chan PingResult, len(servers))
-		done := make(chan struct{})
-
-		pingList := make(map[int]float64)
+		results := probeServers(servers, probeOptions{
+			srcIp:         c.String(defs.OptionSource),
+			iface:         c.String(defs.OptionInterface),
+			network:       network,
+			noICMP:        c.Bool(defs.OptionNoICMP),
+			ping:          true,
+			workers:       c.Int(defs.OptionSelectWorkers),
+			serverTimeout: time.Duration(c.Int(defs.OptionServerTimeout)) * time.Second,
+			timeout:       time.Duration(c.Int(defs.OptionSelectTimeout)) * time.Second,
+			progress:      progressEvents(c, format),
+		})
 
-		// spawn 10 concurrent pingers
-		for i := 0; i < 10; i++ {
-			go pingWorker(jobs, results, &wg, c.String(defs.OptionSource), network, c.Bool(defs.OptionNoICMP))
+		// get the fastest server's index in the `servers` array
+		serverIdx := -1
+		for idx, result := range results {
+			if !result.Up || !result.Pinged {
+				continue
+			}
+			if serverIdx == -1 || result.Ping < results[serverIdx].Ping {
+				serverIdx = idx
+			}
 		}
 
-		// send ping jobs to workers
-		for idx, server := range servers {
-			wg.Add(1)
-			jobs <- PingJob{Index: idx, Server: server}
+		if serverIdx == -1 {
+			for _, result := range results {
+				if result.TLSFailed {
+					log.Error("No server is available, the servers failing the TLS checks were skipped.")
+					return errors.New("no server available")
+				}
+			}
+			log.Error("No server is currently available, please try again later.")
+			return errors.New("no server available")
+		}
+		if progressEvents(c, format) {
+			defs.SendServerSelectionResult(&servers[serverIdx], results[serverIdx].Ping)
 		}
 
-		go func() {
-			wg.Wait()
-			close(done)
-		}()
+		// do speed test on the server
+		return doSpeedTest(c, []defs.Server{servers[serverIdx]}, telemetryServer, network, format, silent, transports, w)
+	})
+}
 
-	Loop:
-		for {
-			select {
-			case result := <-results:
-				pingList[result.Index] = result.Ping
-			case <-done:
-				break Loop
-			}
-		}
+// loadServerList loads the server list, and sets the public keys pinned and the headers of its servers
+func loadServerList(c *cli.Context, pins *keyPins, headers *requestHeaders) ([]defs.Server, error) {
+	servers, err := loadServers(c)
+	if err != nil {
+		log.Errorf("Error when fetching server list: %s", err)
+		return nil, err
+	}
+	if err := pins.set(servers); err != nil {
+		log.Errorf("Error in server list: %s", err)
+		return nil, err
+	}
+	if err := headers.setServers(servers); err != nil {
+		log.Errorf("Error in server list: %s", err)
+		return nil, err
+	}
+	return servers, nil
+}
 
-		if len(pingList) == 0 {
-			log.Fatal("No server is currently available, please try again later.")
-		}
+// probeServers checks all servers with a pool of concurrent workers, and returns the results in the same order as
+// the `servers` array. Servers not probed before the overall timeout are reported as down
+func probeServers(servers []defs.Server, opts probeOptions) []PingResult {
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
 
-		// get the fastest server's index in the `servers` array
-		var serverIdx int
-		for idx, ping := range pingList {
-			if ping > 0 && ping <= pingList[serverIdx] {
-				serverIdx = idx
-			}
+	workers := opts.workers
+	if workers <= 0 {
+		workers = defaultPingWorkers
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan PingJob, len(servers))
+	results := make(chan PingResult, len(servers))
+
+	ret := make([]PingResult, len(servers))
+
+	if opts.progress {
+		defs.SendServerSelectionStart(len(servers))
+	}
+
+	// spawn concurrent pingers
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go pingWorker(ctx, jobs, results, &wg, opts)
+	}
+
+	// send ping jobs to workers, closing the channel lets them exit once all jobs are taken
+	for idx := range servers {
+		ret[idx].Index = idx
+		jobs <- PingJob{Index: idx, Server: &servers[idx]}
+	}
+	close(jobs)
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var probed int
+	for result := range results {
+		ret[result.Index] = result
+		probed++
+		if opts.progress {
+			defs.SendServerSelectionProgress(probed, len(servers))
 		}
+	}
 
-		// do speed test on the server
-		return doSpeedTest(c, []defs.Server{servers[serverIdx]}, telemetryServer, network, silent)
+	if ctx.Err() != nil {
+		log.Infof("Server selection timed out, %d of %d servers probed", probed, len(servers))
 	}
+
+	return ret
 }
 
-func pingWorker(jobs <-chan PingJob, results chan<- PingResult, wg *sync.WaitGroup, srcIp, network string, noICMP bool) {
-	for {
-		job := <-jobs
-		server := job.Server
-		// get the URL of the speed test server from the JSON
-		u, err := server.GetURL()
-		if err != nil {
-			log.Debugf("Server URL is invalid for %s (%s), skipping", server.Name, server.Server)
-			wg.Done()
-			return
+func pingWorker(ctx context.Context, jobs <-chan PingJob, results chan<- PingResult, wg *sync.WaitGroup, opts probeOptions) {
+	defer wg.Done()
+
+	for job := range jobs {
+		// skip the remaining jobs if the overall timeout is reached
+		if ctx.Err() != nil {
+			continue
 		}
 
-		// check the server is up by accessing the ping URL and checking its returned value == empty and status code == 200
-		if server.IsUp() {
-			// skip ICMP if option given
-			server.NoICMP = noICMP
+		jobCtx := ctx
+		var cancel context.CancelFunc = func() {}
+		if opts.serverTimeout > 0 {
+			jobCtx, cancel = context.WithTimeout(ctx, opts.serverTimeout)
+		}
+		results <- probeServer(jobCtx, job, opts)
+		cancel()
+	}
+}
+
+// probeServer checks a single server's status, and its ping and distance if requested
+func probeServer(ctx context.Context, job PingJob, opts probeOptions) PingResult {
+	server := job.Server
+	result := PingResult{Index: job.Index}
 
-			// if server is up, get ping
-			ping, _, err := server.ICMPPingAndJitter(1, srcIp, network)
-			if err != nil {
-				log.Debugf("Can't ping server %s (%s), skipping", server.Name, u.Hostname())
-				wg.Done()
-				return
-			}
-			// return result
-			results <- PingResult{Index: job.Index, Ping: ping}
-			wg.Done()
+	// get the URL of the speed test server from the JSON
+	u, err := server.GetURL()
+	if err != nil {
+		log.Debugf("Server URL is invalid for %s (%s), skipping", server.Name, server.Server)
+		return result
+	}
+
+	// check the server is up by accessing the ping URL and checking its returned value == empty and status code == 200
+	if err := server.IsUp(ctx); err != nil {
+		if isTLSError(err) {
+			log.Errorf("Server %s (%s) failed the TLS checks, skipping: %s", server.Name, u.Hostname(), err)
+			result.TLSFailed = true
 		} else {
 			log.Debugf("Server %s (%s) doesn't seem to be up, skipping", server.Name, u.Hostname())
-			wg.Done()
+		}
+		return result
+	}
+	result.Up = true
+
+	// get the distance reported by the backend if requested
+	if opts.distanceUnit != "" {
+		if ispInfo, err := server.GetIPInfo(ctx, opts.distanceUnit); err != nil {
+			log.Debugf("Can't get distance to server %s (%s): %s", server.Name, u.Hostname(), err)
+		} else {
+			result.Distance, result.DistanceText = parseDistance(ispInfo.ProcessedString)
 		}
 	}
+
+	if opts.ping {
+		// skip ICMP if option given, or if the server is reached through a proxy
+		server.NoICMP = opts.noICMP || proxyFor(u) != nil
+		server.Interface = opts.iface
+
+		// if server is up, get ping
+		ping, _, err := server.ICMPPingAndJitter(ctx, 1, opts.srcIp, opts.network)
+		if err != nil {
+			log.Debugf("Can't ping server %s (%s), skipping", server.Name, u.Hostname())
+		} else {
+			result.Pinged = true
+			result.Ping = ping
+		}
+	}
+
+	return result
 }
 
-// getServerList fetches the server JSON from a remote server
-func getServerList(forceHTTPS bool, serverList string, excludes, specific []int, filter bool) ([]defs.Server, error) {
+// loadServers loads the server lists from all sources given in cli options, merges them and applies the --exclude and
+// --server filters
+func loadServers(c *cli.Context) ([]defs.Server, error) {
+	forceHTTPS := c.Bool(defs.OptionSecure)
+	excludes := c.IntSlice(defs.OptionExclude)
+	specific := c.IntSlice(defs.OptionServer)
+
 	// --exclude and --server cannot be used at the same time
 	if len(excludes) > 0 && len(specific) > 0 {
 		return nil, errors.New("either --exclude or --server can be used")
 	}
 
+	remotes := c.StringSlice(defs.OptionServerJSON)
+	locals := c.StringSlice(defs.OptionLocalJSON)
+	// use the default server list only when no other source is given
+	if len(remotes) == 0 && len(locals) == 0 {
+		remotes = []string{serverListUrl}
+	}
+
+	// lists keeps a slot for every source, nil if it couldn't be loaded, so IDs are namespaced by the source's position
+	var stdinUsed, loaded bool
+	var lists [][]defs.Server
+	for _, str := range remotes {
+		// fetch the server list JSON and parse it into the `servers` array
+		log.Infof("Retrieving server list from %s", str)
+		servers, err := getServerList(forceHTTPS, str)
+		if err != nil {
+			log.Info("Retry with /.well-known/librespeed")
+			servers, err = getServerList(forceHTTPS, str+"/.well-known/librespeed")
+		}
+		if err != nil {
+			log.Errorf("Error when fetching server list from %s: %s", str, err)
+			lists = append(lists, nil)
+			continue
+		}
+		lists = append(lists, setServerSource(servers, str))
+		loaded = true
+	}
+
+	for _, str := range locals {
+		var servers []defs.Server
+		var err error
+		switch str {
+		case "-":
+			if stdinUsed {
+				return nil, errors.New("stdin can only be used once as a server list source")
+			}
+			stdinUsed = true
+			// load server list from stdin
+			log.Info("Using local JSON server list from stdin")
+			servers, err = getLocalServersReader(forceHTTPS, os.Stdin)
+			str = "stdin"
+		default:
+			// load server list from local JSON file
+			log.Infof("Using local JSON server list: %s", str)
+			servers, err = getLocalServers(forceHTTPS, str)
+		}
+		if err != nil {
+			log.Errorf("Error when loading server list from %s: %s", str, err)
+			lists = append(lists, nil)
+			continue
+		}
+		lists = append(lists, setServerSource(servers, str))
+		loaded = true
+	}
+
+	if !loaded {
+		return nil, errors.New("no server list could be loaded")
+	}
+
+	servers := mergeServers(lists)
+
+	// do not filter the servers when --list is given
+	if c.Bool(defs.OptionList) {
+		return servers, nil
+	}
+	return filterServers(servers, excludes, specific), nil
+}
+
+// getServerList fetches the server JSON from a remote server
+func getServerList(forceHTTPS bool, serverList string) ([]defs.Server, error) {
 	// getting the server list from remote
 	var servers []defs.Server
 	req, err := http.NewRequest(http.MethodGet, serverList, nil)
@@ -376,11 +659,11 @@ func getServerList(forceHTTPS bool, serverList string, excludes, specific []int,
 		return nil, err
 	}
 
-	return preprocessServers(servers, forceHTTPS, excludes, specific, filter)
+	return preprocessServers(servers, forceHTTPS)
 }
 
 // getLocalServersReader loads the server JSON from an io.Reader
-func getLocalServersReader(forceHTTPS bool, reader io.ReadCloser, excludes, specific []int, filter bool) ([]defs.Server, error) {
+func getLocalServersReader(forceHTTPS bool, reader io.ReadCloser) ([]defs.Server, error) {
 	defer reader.Close()
 
 	var servers []defs.Server
@@ -394,20 +677,20 @@ func getLocalServersReader(forceHTTPS bool, reader io.ReadCloser, excludes, spec
 		return nil, err
 	}
 
-	return preprocessServers(servers, forceHTTPS, excludes, specific, filter)
+	return preprocessServers(servers, forceHTTPS)
 }
 
 // getLocalServers loads the server JSON from a local file
-func getLocalServers(forceHTTPS bool, jsonFile string, excludes, specific []int, filter bool) ([]defs.Server, error) {
+func getLocalServers(forceHTTPS bool, jsonFile string) ([]defs.Server, error) {
 	f, err := os.OpenFile(jsonFile, os.O_RDONLY, 0644)
 	if err != nil {
 		return nil, err
 	}
-	return getLocalServersReader(forceHTTPS, f, excludes, specific, filter)
+	return getLocalServersReader(forceHTTPS, f)
 }
 
 // preprocessServers makes some needed modifications to the servers fetched
-func preprocessServers(servers []defs.Server, forceHTTPS bool, excludes, specific []int, filter bool) ([]defs.Server, error) {
+func preprocessServers(servers []defs.Server, forceHTTPS bool) ([]defs.Server, error) {
 	for i := range servers {
 		u, err := servers[i].GetURL()
 		if err != nil {
@@ -427,37 +710,103 @@ func preprocessServers(servers []defs.Server, forceHTTPS bool, excludes, specifi
 		servers[i].Server = u.String()
 	}
 
-	if len(excludes) > 0 && len(specific) > 0 {
-		return nil, errors.New("either --exclude or --specific can be used")
+	return servers, nil
+}
+
+// setServerSource records the list source in every server loaded from it
+func setServerSource(servers []defs.Server, source string) []defs.Server {
+	for i := range servers {
+		servers[i].Source = source
 	}
+	return servers
+}
 
-	if filter {
-		// exclude servers from --exclude
-		if len(excludes) > 0 {
-			var ret []defs.Server
-			for _, server := range servers {
-				if contains(excludes, server.ID) {
-					continue
-				}
-				ret = append(ret, server)
+// mergeServers merges the server lists loaded from different sources, in the order of the sources. Servers pointing to
+// a backend URL seen in an earlier source are dropped, and if the remaining servers of a list have IDs colliding with the
+// ones loaded before it, all IDs in that list are namespaced with the source's position, e.g. ID 5 of the 3rd source
+// becomes 200005. The entries of a single list are kept as is. Sources that couldn't be loaded are nil, and keep their
+// position
+func mergeServers(lists [][]defs.Server) []defs.Server {
+	var ret []defs.Server
+	seenIDs := make(map[int]bool)
+	seenURLs := make(map[string]bool)
+
+	for idx, list := range lists {
+		var servers []defs.Server
+		var collision bool
+		for _, server := range list {
+			if seenURLs[serverKey(server)] {
+				log.Debugf("Server %s (%s) from %s is a duplicate, skipping", server.Name, server.Server, server.Source)
+				continue
+			}
+			servers = append(servers, server)
+			if seenIDs[server.ID] {
+				collision = true
 			}
-			return ret, nil
 		}
 
-		// use only servers from --server
-		// special value -1 will test all servers
-		if len(specific) > 0 && !contains(specific, -1) {
-			var ret []defs.Server
-			for _, server := range servers {
-				if contains(specific, server.ID) {
-					ret = append(ret, server)
-				}
+		// the IDs and URLs of this list are only checked against the next sources
+		listIDs := make(map[int]bool)
+		for _, server := range servers {
+			if collision {
+				id := idx*serverIDNamespace + server.ID
+				log.Debugf("Server ID %d from %s collides with another source, using %d", server.ID, server.Source, id)
+				server.ID = id
 			}
-			return ret, nil
+
+			if seenIDs[server.ID] {
+				log.Warnf("Server %s (%s) from %s has a conflicting ID %d, skipping", server.Name, server.Server, server.Source, server.ID)
+				continue
+			}
+
+			listIDs[server.ID] = true
+			seenURLs[serverKey(server)] = true
+			ret = append(ret, server)
+		}
+		for id := range listIDs {
+			seenIDs[id] = true
 		}
 	}
 
-	return servers, nil
+	return ret
+}
+
+// serverKey returns the normalized backend URL used to detect duplicated servers across lists
+func serverKey(server defs.Server) string {
+	u, err := server.GetURL()
+	if err != nil {
+		return server.Server
+	}
+	return strings.ToLower(u.Scheme+"://"+u.Host) + strings.TrimSuffix(u.Path, "/")
+}
+
+// filterServers applies --exclude and --server to the server list
+func filterServers(servers []defs.Server, excludes, specific []int) []defs.Server {
+	// exclude servers from --exclude
+	if len(excludes) > 0 {
+		var ret []defs.Server
+		for _, server := range servers {
+			if contains(excludes, server.ID) {
+				continue
+			}
+			ret = append(ret, server)
+		}
+		return ret
+	}
+
+	// use only servers from --server
+	// special value -1 will test all servers
+	if len(specific) > 0 && !contains(specific, -1) {
+		var ret []defs.Server
+		for _, server := range servers {
+			if contains(specific, server.ID) {
+				ret = append(ret, server)
+			}
+		}
+		return ret
+	}
+
+	return servers
 }
 
 // contains is a helper function to check if an int is in an int array
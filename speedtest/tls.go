@@ -0,0 +1,171 @@
+package speedtest
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"librespeed-cli/defs"
+)
+
+// newTLSConfig creates the TLS configuration shared by all requests, from --skip-cert-verify, --ca-cert,
+// --client-cert and --client-key. The public keys pinned in pins are checked for every connection
+func newTLSConfig(skipCertVerify bool, caCert, clientCert, clientKey string, pins *keyPins) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: skipCertVerify}
+
+	if caCert != "" {
+		b, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", caCert)
+		}
+		cfg.RootCAs = pool
+	}
+
+	// the key can be in the same PEM file as the certificate
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" {
+			return nil, errors.New("client key given without a client certificate")
+		}
+		if clientKey == "" {
+			clientKey = clientCert
+		}
+
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if pins != nil {
+		cfg.VerifyConnection = pins.verify
+	}
+
+	return cfg, nil
+}
+
+// errKeyPin is returned when the certificate of a pinned host doesn't have one of the pinned public keys
+var errKeyPin = errors.New("public key pinning failed")
+
+// keyPins holds the SHA-256 hashes of the public keys pinned per server host
+type keyPins struct {
+	mu    sync.RWMutex
+	hosts map[string][]string
+}
+
+// set replaces the pins with the ones of the given servers
+func (p *keyPins) set(servers []defs.Server) error {
+	hosts := make(map[string][]string)
+	for _, server := range servers {
+		if len(server.PinSHA256) == 0 {
+			continue
+		}
+
+		u, err := url.Parse(server.Server)
+		if err != nil {
+			return err
+		}
+		for _, pin := range server.PinSHA256 {
+			hash, err := parsePin(pin)
+			if err != nil {
+				return fmt.Errorf("invalid pin for server %s: %s", server.Name, err)
+			}
+			hosts[u.Hostname()] = append(hosts[u.Hostname()], hash)
+		}
+	}
+
+	p.mu.Lock()
+	p.hosts = hosts
+	p.mu.Unlock()
+	return nil
+}
+
+// verify checks the pins of connections whose dialed host isn't known, like the ones tunneled through a proxy, by the
+// server name. The server name is empty for IP addresses, so those fail if an IP address is pinned
+func (p *keyPins) verify(cs tls.ConnectionState) error {
+	if cs.ServerName == "" {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		for host := range p.hosts {
+			if net.ParseIP(host) != nil {
+				return fmt.Errorf("%w: can't check the pinned keys of %s, the host connected to is unknown", errKeyPin, host)
+			}
+		}
+		return nil
+	}
+	return p.check(cs.ServerName, cs)
+}
+
+// verifyHost returns a function checking the pins of a connection to host
+func (p *keyPins) verifyHost(host string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		return p.check(host, cs)
+	}
+}
+
+// check checks that the certificate presented by a pinned host has one of the pinned public keys
+func (p *keyPins) check(host string, cs tls.ConnectionState) error {
+	p.mu.RLock()
+	pins := p.hosts[host]
+	p.mu.RUnlock()
+
+	if len(pins) == 0 {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("%w: no certificate presented by pinned host %s", errKeyPin, host)
+	}
+
+	sum := sha256.Sum256(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	hash := base64.StdEncoding.EncodeToString(sum[:])
+	for _, pin := range pins {
+		if pin == hash {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: public key of %s (sha256//%s) doesn't match the pinned keys", errKeyPin, host, hash)
+}
+
+// isTLSError tells whether err is a failure to verify the server, or to authenticate to it, rather than a server down.
+// Those aren't solved by trying again later
+func isTLSError(err error) bool {
+	var (
+		certErr      *tls.CertificateVerificationError
+		alertErr     tls.AlertError
+		unknownCAErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		recordErr    tls.RecordHeaderError
+	)
+	return errors.Is(err, errKeyPin) || errors.As(err, &certErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &unknownCAErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		errors.As(err, &recordErr)
+}
+
+// parsePin normalizes a pin given as base64 SHA-256 hash of the public key, optionally prefixed with "sha256//" like
+// curl's --pinnedpubkey
+func parsePin(pin string) (string, error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256//")
+
+	b, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil {
+		return "", err
+	}
+	if len(b) != sha256.Size {
+		return "", fmt.Errorf("%s is not a SHA-256 hash", pin)
+	}
+	return pin, nil
+}
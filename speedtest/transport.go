@@ -0,0 +1,468 @@
+package speedtest
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	httpVersion11 = "1.1"
+	httpVersion2  = "2"
+	httpVersion3  = "3"
+)
+
+// transportOptions holds the settings used to build the HTTP transport shared by all requests
+type transportOptions struct {
+	network   string
+	source    *net.TCPAddr
+	tlsConfig *tls.Config
+	proxy     func(*http.Request) (*url.URL, error)
+	// proxyGiven is set when the proxy is given by --proxy, rather than from the environment
+	proxyGiven bool
+	resolver   *net.Resolver
+	overrides  map[string]string
+	// pins are the public keys pinned per server host, checked against the host dialed
+	pins *keyPins
+	// control binds the sockets to the network interface given in --interface
+	control func(network, address string, c syscall.RawConn) error
+}
+
+// newTransport creates a HTTP transport modified from http.DefaultTransport, that binds the source address, forces the
+// IP version and goes through the proxy given in options
+func newTransport(opts transportOptions) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = opts.tlsConfig.Clone()
+	transport.Proxy = opts.proxy
+
+	// when a proxy is used, the dialer connects to the proxy instead of the server
+	transport.DialContext = newDialContext(opts)
+
+	// the TLS handshake is done here to check the pins of the host dialed, the server name is empty for IP addresses.
+	// Connections tunneled through a proxy are checked by the VerifyConnection of the TLS config instead
+	if opts.pins != nil {
+		transport.DialTLSContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			conn, err := transport.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+
+			cfg := transport.TLSClientConfig.Clone()
+			if cfg.ServerName == "" {
+				cfg.ServerName = host
+			}
+			cfg.VerifyConnection = opts.pins.verifyHost(host)
+
+			ctx, cancel := context.WithTimeout(ctx, transport.TLSHandshakeTimeout)
+			defer cancel()
+			tlsConn := tls.Client(conn, cfg)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+
+	return transport
+}
+
+// newDialContext returns a dial function binding the source address and interface, forcing the IP version, and
+// resolving with the resolver and overrides given in options
+func newDialContext(opts transportOptions) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   opts.control,
+		// use the resolver from --dns-server if given
+		Resolver: opts.resolver,
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		d := dialer
+		if opts.source != nil {
+			if strings.HasPrefix(network, "udp") {
+				d.LocalAddr = &net.UDPAddr{IP: opts.source.IP}
+			} else {
+				d.LocalAddr = opts.source
+			}
+		}
+		switch opts.network {
+		case "ip4":
+			network = strings.TrimRight(network, "46") + "4"
+		case "ip6":
+			network = strings.TrimRight(network, "46") + "6"
+		}
+		return d.DialContext(ctx, network, resolveOverride(opts.overrides, address))
+	}
+}
+
+// testTransport is a HTTP round tripper used for the tests, forcing the HTTP version unless version is empty, and the
+// IP version unless network is empty
+type testTransport struct {
+	version string
+	network string
+	rt      http.RoundTripper
+}
+
+// newTestTransports creates the round trippers for the HTTP versions given in --http-version and the IP versions tested
+// with --dual-stack. Without any of them, the base transport is used and the protocol is negotiated as usual
+func newTestTransports(versions, networks []string, opts transportOptions, base *http.Transport) ([]testTransport, error) {
+	if len(versions) == 0 && len(networks) == 0 {
+		return []testTransport{{rt: base}}, nil
+	}
+
+	// versions can also be given as a comma separated list
+	var list []string
+	seen := make(map[string]bool)
+	for _, version := range versions {
+		for _, v := range strings.Split(version, ",") {
+			v = strings.TrimSpace(v)
+			if v == "1" {
+				v = httpVersion11
+			}
+			if !seen[v] {
+				seen[v] = true
+				list = append(list, v)
+			}
+		}
+	}
+	if len(list) == 0 {
+		list = []string{""}
+	}
+	if len(networks) == 0 {
+		networks = []string{""}
+	}
+
+	var ret []testTransport
+	for _, network := range networks {
+		netOpts := opts
+		if network != "" {
+			netOpts.network = network
+		}
+
+		for _, version := range list {
+			var rt http.RoundTripper
+			switch version {
+			case "":
+				rt = newTransport(netOpts)
+			case httpVersion11, httpVersion2:
+				transport := newTransport(netOpts)
+				var protocols http.Protocols
+				if version == httpVersion11 {
+					protocols.SetHTTP1(true)
+				} else {
+					// use HTTP/2 with prior knowledge for plain HTTP servers
+					protocols.SetHTTP2(true)
+					protocols.SetUnencryptedHTTP2(true)
+				}
+				transport.Protocols = &protocols
+				rt = transport
+			case httpVersion3:
+				if opts.proxyGiven {
+					closeTestTransports(ret)
+					return nil, errHTTP3Proxy
+				}
+				transport, err := newHTTP3Transport(netOpts)
+				if err != nil {
+					closeTestTransports(ret)
+					return nil, err
+				}
+				rt = transport
+			default:
+				closeTestTransports(ret)
+				return nil, fmt.Errorf("unsupported HTTP version: %s", version)
+			}
+
+			ret = append(ret, testTransport{version: version, network: network, rt: rt})
+		}
+	}
+
+	return ret, nil
+}
+
+// http3Transport is a HTTP/3 round tripper owning the QUIC transport it dials with
+type http3Transport struct {
+	*http3.Transport
+	quic *quic.Transport
+}
+
+// Close closes the HTTP/3 connections and the underlying UDP socket
+func (t *http3Transport) Close() error {
+	t.Transport.Close()
+	return t.quic.Close()
+}
+
+// errHTTP3Proxy is returned when HTTP/3 is forced for a server reached through a proxy, QUIC can't be proxied
+var errHTTP3Proxy = errors.New("HTTP/3 cannot be used through a proxy")
+
+// newHTTP3Transport creates a HTTP/3 round tripper. All QUIC connections share a single UDP socket bound to the source
+// address and interface. Proxies are not supported, connecting to a server that would be proxied fails instead of
+// bypassing the proxy
+func newHTTP3Transport(opts transportOptions) (*http3Transport, error) {
+	network := "udp"
+	switch opts.network {
+	case "ip4":
+		network = "udp4"
+	case "ip6":
+		network = "udp6"
+	}
+
+	localAddr := &net.UDPAddr{}
+	if opts.source != nil {
+		localAddr.IP = opts.source.IP
+	}
+
+	lc := net.ListenConfig{Control: opts.control}
+	conn, err := lc.ListenPacket(context.Background(), network, localAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	qt := &quic.Transport{Conn: conn}
+
+	transport := &http3.Transport{
+		TLSClientConfig: opts.tlsConfig.Clone(),
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			// e.g. HTTPS_PROXY from the environment
+			if opts.proxy != nil {
+				proxyUrl, err := opts.proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}, Header: make(http.Header)})
+				if err != nil {
+					return nil, err
+				}
+				if proxyUrl != nil {
+					return nil, errHTTP3Proxy
+				}
+			}
+			udpAddr, err := lookupUDPAddr(ctx, opts, resolveOverride(opts.overrides, addr))
+			if err != nil {
+				return nil, err
+			}
+			if opts.pins != nil {
+				host, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				tlsCfg = tlsCfg.Clone()
+				tlsCfg.VerifyConnection = opts.pins.verifyHost(host)
+			}
+			return qt.DialEarly(ctx, udpAddr, tlsCfg, cfg)
+		},
+	}
+
+	return &http3Transport{Transport: transport, quic: qt}, nil
+}
+
+// lookupUDPAddr resolves the "host:port" address with the resolver from options, picking the first address of the
+// forced IP version
+func lookupUDPAddr(ctx context.Context, opts transportOptions, address string) (*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.LookupPort("udp", portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := opts.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		isIPv4 := addr.IP.To4() != nil
+		if (opts.network == "ip4" && !isIPv4) || (opts.network == "ip6" && isIPv4) {
+			continue
+		}
+		return &net.UDPAddr{IP: addr.IP, Port: port, Zone: addr.Zone}, nil
+	}
+	return nil, fmt.Errorf("no suitable address found for %s", host)
+}
+
+// closeTestTransports releases the resources held by the test transports, like the UDP socket of HTTP/3
+func closeTestTransports(transports []testTransport) {
+	for _, t := range transports {
+		if closer, ok := t.rt.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// parseProxy returns the proxy function for the --proxy option. Without the option, HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables are honored, while "direct" disables proxies altogether
+func parseProxy(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.New("unsupported proxy scheme, only http, https and socks5 are supported")
+	}
+
+	if u.Host == "" {
+		return nil, errors.New("proxy host is missing")
+	}
+
+	return http.ProxyURL(u), nil
+}
+
+// proxyFor returns the proxy used by the default HTTP client to reach the given URL, or nil for direct connections
+func proxyFor(u *url.URL) *url.URL {
+	rt := http.DefaultClient.Transport
+	if ht, ok := rt.(*headerTransport); ok {
+		rt = ht.base
+	}
+	transport, ok := rt.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		return nil
+	}
+
+	proxyUrl, err := transport.Proxy(&http.Request{URL: u, Header: make(http.Header)})
+	if err != nil {
+		return nil
+	}
+	return proxyUrl
+}
+
+// redactURL returns the URL as a string with the password removed, for logs and reports
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	ru := *u
+	if _, ok := ru.User.Password(); ok {
+		ru.User = url.UserPassword(ru.User.Username(), "xxxxx")
+	}
+	return ru.String()
+}
+
+// newSinkDialer returns the dial function of the sinks sending over TCP or UDP, like Graphite or MQTT. TCP connections
+// go through the proxy as HTTPS requests would, UDP can't be proxied and is sent directly
+func newSinkDialer(opts transportOptions) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := newDialContext(opts)
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if network != "tcp" || opts.proxy == nil {
+			return dial(ctx, network, address)
+		}
+
+		proxyUrl, err := opts.proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: address}, Header: make(http.Header)})
+		if err != nil {
+			return nil, err
+		}
+		if proxyUrl == nil {
+			return dial(ctx, network, address)
+		}
+		return dialProxy(ctx, dial, proxyUrl, address)
+	}
+}
+
+// dialFunc is a dial function, usable as the dialer of a SOCKS5 proxy
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Dial implements proxy.Dialer
+func (f dialFunc) Dial(network, address string) (net.Conn, error) {
+	return f(context.Background(), network, address)
+}
+
+// DialContext implements proxy.ContextDialer
+func (f dialFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// dialProxy opens a TCP tunnel to address through the proxy, with CONNECT for HTTP proxies
+func dialProxy(ctx context.Context, dial dialFunc, proxyUrl *url.URL, address string) (net.Conn, error) {
+	scheme := strings.ToLower(proxyUrl.Scheme)
+	proxyAddr := proxyUrl.Host
+	if proxyUrl.Port() == "" {
+		port := map[string]string{"http": "80", "https": "443", "socks5": "1080"}[scheme]
+		proxyAddr = net.JoinHostPort(proxyUrl.Hostname(), port)
+	}
+
+	if scheme == "socks5" {
+		var auth *proxy.Auth
+		if proxyUrl.User != nil {
+			password, _ := proxyUrl.User.Password()
+			auth = &proxy.Auth{User: proxyUrl.User.Username(), Password: password}
+		}
+		d, err := proxy.SOCKS5("tcp", proxyAddr, auth, dial)
+		if err != nil {
+			return nil, err
+		}
+		return d.(proxy.ContextDialer).DialContext(ctx, "tcp", address)
+	}
+
+	conn, err := dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyUrl.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: address}, Host: address, Header: make(http.Header)}
+	if proxyUrl.User != nil {
+		password, _ := proxyUrl.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyUrl.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the server of the tunnel doesn't write before the sink, nothing is buffered past the response
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the body of a successful CONNECT is the tunnel, it isn't read
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused the connection to %s: %s", redactURL(proxyUrl), address, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,228 @@
+package tui
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"librespeed-cli/report"
+)
+
+const (
+	// graphHeight is the number of rows of the throughput graphs
+	graphHeight = 4
+	// histogramBins is the largest number of bins of the latency histogram
+	histogramBins = 6
+	// minLogLines is the number of rows of the log pane, if the terminal is high enough
+	minLogLines = 3
+)
+
+// levels are the characters of the throughput graphs, from empty to full
+var levels = []rune(" ▁▂▃▄▅▆▇█")
+
+// render returns the lines of the view for a terminal of the size given
+func (ui *UI) render(width, height int) []string {
+	s := &ui.state
+	u := ui.units
+
+	lines := []string{"\x1b[7m" + fit(" LibreSpeed CLI", width)}
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fit(fmt.Sprintf(format, args...), width))
+	}
+
+	server := "-"
+	if s.server.Name != "" {
+		server = fmt.Sprintf("%s (%s)", s.server.Name, s.server.Host)
+		if s.server.Location != "" {
+			server += " - " + s.server.Location
+		}
+	}
+	client := "-"
+	if s.clientIP != "" {
+		client = s.clientIP
+		if s.isp != "" {
+			client += " - " + s.isp
+		}
+	}
+	add("Server:  %s", server)
+	add("Client:  %s", client)
+	if s.phase != "" {
+		add("Phase:   %-30s %s %3.0f%%", s.phase, bar(s.progress, 20), s.progress*100)
+	} else {
+		add("Phase:   Starting")
+	}
+
+	add("")
+	if len(s.pings) > 0 {
+		add("Ping:    %.0f ms    Jitter: %.0f ms    Replies: %d", mean(s.pings), s.jitter, len(s.pings))
+		for _, line := range histogram(s.pings, width-16) {
+			add("%s", line)
+		}
+	} else {
+		add("Ping:    -")
+	}
+
+	for _, t := range []struct {
+		name string
+		transfer
+	}{{"Download", s.download}, {"Upload", s.upload}} {
+		add("")
+		if len(t.samples) == 0 {
+			add("%-9s -", t.name+":")
+			continue
+		}
+		add("%-9s now %s    avg %s    peak %s", t.name+":", u.Rate(t.current), u.Rate(t.avg), u.Rate(t.peak))
+		for _, line := range graph(t.samples, t.peak, width-2) {
+			add(" %s", line)
+		}
+	}
+
+	if len(s.results) > 0 {
+		add("")
+		var b strings.Builder
+		report.WriteTable(&b, s.results, u)
+		for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+			add("%s", line)
+		}
+	}
+
+	// the log pane takes the remaining rows
+	logLines := height - len(lines) - 2
+	if logLines < minLogLines {
+		logLines = minLogLines
+	}
+	if logLines > len(ui.logs) {
+		logLines = len(ui.logs)
+	}
+	if logLines > 0 {
+		add("")
+		for _, line := range ui.logs[len(ui.logs)-logLines:] {
+			add("%s", strings.TrimRight(line, "\r\n"))
+		}
+	}
+
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+// graph returns the rows of a graph of the samples, scaled to max, with a column per sample of the latest samples
+// fitting in width
+func graph(samples []float64, max float64, width int) []string {
+	if width < 1 {
+		return nil
+	}
+	if len(samples) > width {
+		samples = samples[len(samples)-width:]
+	}
+
+	rows := make([]string, graphHeight)
+	for row := range rows {
+		var b strings.Builder
+		// eighths of a row filled below this row
+		base := float64((graphHeight - 1 - row) * 8)
+		for _, v := range samples {
+			var level float64
+			if max > 0 {
+				level = v / max * graphHeight * 8
+			}
+			idx := int(math.Round(math.Min(math.Max(level-base, 0), 8)))
+			b.WriteRune(levels[idx])
+		}
+		rows[row] = b.String()
+	}
+	return rows
+}
+
+// histogram returns the rows of a histogram of the latencies, with bars fitting in width
+func histogram(pings []float64, width int) []string {
+	min, max := pings[0], pings[0]
+	for _, p := range pings {
+		min = math.Min(min, p)
+		max = math.Max(max, p)
+	}
+
+	bins := histogramBins
+	if max == min {
+		bins = 1
+	}
+	step := (max - min) / float64(bins)
+	counts := make([]int, bins)
+	var most int
+	for _, p := range pings {
+		idx := bins - 1
+		if step > 0 {
+			idx = int(math.Min((p-min)/step, float64(bins-1)))
+		}
+		counts[idx]++
+		if counts[idx] > most {
+			most = counts[idx]
+		}
+	}
+
+	rows := make([]string, bins)
+	for i, count := range counts {
+		n := 0
+		if most > 0 && width > 0 {
+			n = count * width / most
+		}
+		rows[i] = fmt.Sprintf("  %6.0f ms %s %d", min+step*float64(i), strings.Repeat("█", n), count)
+	}
+	return rows
+}
+
+// bar returns a progress bar of the width given
+func bar(progress float64, width int) string {
+	n := int(math.Round(math.Min(math.Max(progress, 0), 1) * float64(width)))
+	return "[" + strings.Repeat("#", n) + strings.Repeat("-", width-n) + "]"
+}
+
+// fit pads or truncates the line to the width given. ANSI escape sequences, like the colors of the log lines, take no
+// width and are never cut, and the attributes are reset at the end of the line
+func fit(s string, width int) string {
+	var b strings.Builder
+	n := 0
+	r := []rune(s)
+	for i := 0; i < len(r); i++ {
+		if r[i] == '\x1b' {
+			end := escapeEnd(r, i)
+			b.WriteString(string(r[i:end]))
+			i = end - 1
+			continue
+		}
+		if n < width {
+			b.WriteRune(r[i])
+			n++
+		}
+	}
+	b.WriteString(strings.Repeat(" ", width-n))
+	b.WriteString("\x1b[0m")
+	return b.String()
+}
+
+// escapeEnd returns the index following the escape sequence starting at r[i]. Control sequences like colors end with
+// a character from @ to ~, other sequences are a single character after ESC
+func escapeEnd(r []rune, i int) int {
+	if i+1 >= len(r) {
+		return len(r)
+	}
+	if r[i+1] != '[' {
+		return i + 2
+	}
+	for j := i + 2; j < len(r); j++ {
+		if r[j] >= '@' && r[j] <= '~' {
+			return j + 1
+		}
+	}
+	return len(r)
+}
+
+// mean returns the average of the values
+func mean(values []float64) float64 {
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
@@ -0,0 +1,27 @@
+package tui
+
+import "testing"
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		in    string
+		width int
+		want  string
+	}{
+		{"abc", 5, "abc  \x1b[0m"},
+		{"abcdef", 3, "abc\x1b[0m"},
+		{"débit", 3, "déb\x1b[0m"},
+		{"", 2, "  \x1b[0m"},
+		{"\x1b[31mred\x1b[0m", 5, "\x1b[31mred\x1b[0m  \x1b[0m"},
+		{"\x1b[31mred text\x1b[0m", 3, "\x1b[31mred\x1b[0m\x1b[0m"},
+		{"ab\x1b[1;31mcd", 2, "ab\x1b[1;31m\x1b[0m"},
+		{"abc\x1b[3", 5, "abc\x1b[3  \x1b[0m"},
+		{"a\x1b", 2, "a\x1b \x1b[0m"},
+	}
+
+	for _, tt := range tests {
+		if got := fit(tt.in, tt.width); got != tt.want {
+			t.Errorf("fit(%q, %d) = %q, want %q", tt.in, tt.width, got, tt.want)
+		}
+	}
+}
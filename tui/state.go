@@ -0,0 +1,89 @@
+package tui
+
+import (
+	"math"
+
+	"librespeed-cli/defs"
+	"librespeed-cli/report"
+)
+
+// state is what the view shows, updated by the events of the tests
+type state struct {
+	phase    string
+	progress float64
+
+	server   defs.JSONProgressServerInfo
+	isp      string
+	clientIP string
+
+	pings  []float64
+	jitter float64
+
+	download transfer
+	upload   transfer
+
+	results []report.JSONReport
+}
+
+// transfer is the throughput of a download or upload test, sampled by its progress events
+type transfer struct {
+	// samples are the rates between two progress events, in bit/s
+	samples []float64
+	current float64
+	avg     float64
+	peak    float64
+
+	bytes     int
+	elapsedMs int64
+}
+
+// update applies an event to the state
+func (s *state) update(e defs.Event) {
+	switch e := e.(type) {
+	case *defs.JSONProgressServerSelectionStart:
+		s.phase = "Selecting the fastest server"
+		s.progress = 0
+	case *defs.JSONProgressServerSelection:
+		s.phase = "Selecting the fastest server"
+		s.progress = e.ServerSelection.Progress
+	case *defs.JSONProgressServerSelectionResult:
+		s.server = e.Server
+	case *defs.JSONProgressHeader:
+		s.phase = "Pinging"
+		s.progress = 0
+		s.server = e.Server
+		s.isp = e.ISP
+		s.clientIP = e.Interface.ExternalIP
+		s.pings = nil
+		s.jitter = 0
+		s.download = transfer{}
+		s.upload = transfer{}
+	case *defs.JSONProgressPing:
+		s.phase = "Pinging"
+		s.progress = e.Ping.Progress
+		s.pings = append(s.pings, e.Ping.LatencyMs)
+		s.jitter = e.Ping.JitterMs
+	case *defs.JSONProgressDownload:
+		s.phase = "Downloading"
+		s.progress = e.Download.Progress
+		s.download.add(e.Download.Bytes, e.Download.ElapsedMs, e.Download.BitrateBps)
+	case *defs.JSONProgressUpload:
+		s.phase = "Uploading"
+		s.progress = e.Upload.Progress
+		s.upload.add(e.Upload.Bytes, e.Upload.ElapsedMs, e.Upload.BitrateBps)
+	}
+}
+
+// add samples the rate since the previous progress event of the transfer
+func (t *transfer) add(bytes int, elapsedMs int64, avg float64) {
+	if elapsedMs <= t.elapsedMs {
+		return
+	}
+
+	t.current = float64(bytes-t.bytes) * 8 / (float64(elapsedMs-t.elapsedMs) / 1000)
+	t.samples = append(t.samples, t.current)
+	t.avg = avg
+	t.peak = math.Max(t.peak, t.current)
+	t.bytes = bytes
+	t.elapsedMs = elapsedMs
+}
@@ -0,0 +1,18 @@
+package tui
+
+import (
+	"os"
+
+	"github.com/mattn/go-isatty"
+)
+
+const (
+	// defaultWidth and defaultHeight are the size of the view when the size of the terminal is unknown
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// isTerminal tells whether the file is a terminal, including the terminals of Cygwin and MSYS2
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package tui
+
+import "os"
+
+// termSize returns the default size, the size of the terminal is unknown on this platform
+func termSize(*os.File) (int, int) {
+	return defaultWidth, defaultHeight
+}
+
+// enableANSI is a no-op on this platform
+func enableANSI(*os.File) {}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package tui
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// termSize returns the size of the terminal
+func termSize(f *os.File) (int, int) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return defaultWidth, defaultHeight
+	}
+	return int(ws.Col), int(ws.Row)
+}
+
+// enableANSI is a no-op, Unix terminals understand ANSI escape sequences
+func enableANSI(*os.File) {}
@@ -0,0 +1,207 @@
+// Package tui shows the progress and results of the tests as a full-screen live view on the terminal, drawn with ANSI
+// escape sequences
+package tui
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"librespeed-cli/defs"
+	"librespeed-cli/report"
+	"librespeed-cli/units"
+)
+
+const (
+	// refreshInterval is the interval the screen is redrawn at
+	refreshInterval = 200 * time.Millisecond
+	// maxLogLines is the number of log lines kept for the log pane
+	maxLogLines = 100
+)
+
+// ANSI escape sequences switching to the alternate screen and back, and hiding the cursor meanwhile
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+)
+
+// UI is a report.Writer showing the live view until the results are written, then the results of the writer it
+// wraps. The events of the tests are received as a defs.Listener, and the logs are shown in a pane of the view
+type UI struct {
+	out   *os.File
+	inner report.Writer
+	units units.Units
+
+	mu     sync.Mutex
+	state  state
+	logs   []string
+	logBuf bytes.Buffer
+
+	logOut  io.Writer
+	signals chan os.Signal
+	done    chan struct{}
+	stopped bool
+	ended   bool
+}
+
+// New returns a UI drawn on the terminal out, writing the results with inner once the tests are done
+func New(out *os.File, inner report.Writer, u units.Units) *UI {
+	return &UI{out: out, inner: inner, units: u}
+}
+
+// IsTerminal tells whether the file is a terminal
+func IsTerminal(f *os.File) bool {
+	return isTerminal(f)
+}
+
+// Begin implements report.Writer, switching to the live view
+func (ui *UI) Begin() error {
+	if err := ui.inner.Begin(); err != nil {
+		return err
+	}
+
+	enableANSI(ui.out)
+	if _, err := io.WriteString(ui.out, enterScreen); err != nil {
+		return err
+	}
+
+	ui.logOut = log.StandardLogger().Out
+	log.SetOutput(logWriter{ui})
+	// fatal errors exit without returning, the terminal is restored first
+	log.RegisterExitHandler(ui.Stop)
+	defs.SetListener(ui.onEvent)
+
+	// restore the terminal when interrupted
+	ui.signals = make(chan os.Signal, 1)
+	signal.Notify(ui.signals, os.Interrupt, syscall.SIGTERM)
+	ui.done = make(chan struct{})
+	go ui.run()
+	return nil
+}
+
+// WriteResult implements report.Writer, adding the result to the table of the view
+func (ui *UI) WriteResult(rep report.JSONReport) error {
+	ui.mu.Lock()
+	ui.state.results = append(ui.state.results, rep)
+	ui.mu.Unlock()
+
+	return ui.inner.WriteResult(rep)
+}
+
+// End implements report.Writer, leaving the live view before the results are written
+func (ui *UI) End() error {
+	ui.mu.Lock()
+	ui.ended = true
+	ui.mu.Unlock()
+
+	ui.Stop()
+	return ui.inner.End()
+}
+
+// Stop leaves the live view and restores the log output. If the tests didn't end, the logs are written to the log
+// output, so errors aren't lost with the view. Stop can be called several times
+func (ui *UI) Stop() {
+	ui.mu.Lock()
+	if ui.stopped || ui.done == nil {
+		ui.mu.Unlock()
+		return
+	}
+	ui.stopped = true
+	close(ui.done)
+	signal.Stop(ui.signals)
+	ended := ui.ended
+	logs := strings.Join(ui.logs, "")
+	ui.mu.Unlock()
+
+	defs.SetListener(nil)
+	io.WriteString(ui.out, leaveScreen)
+	log.SetOutput(ui.logOut)
+	if !ended {
+		io.WriteString(ui.logOut, logs)
+	}
+}
+
+// run redraws the view periodically until stopped
+func (ui *UI) run() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ui.done:
+			return
+		case <-ui.signals:
+			ui.Stop()
+			os.Exit(130)
+		case <-ticker.C:
+			ui.draw()
+		}
+	}
+}
+
+// draw renders the view and writes it over the previous one
+func (ui *UI) draw() {
+	width, height := termSize(ui.out)
+
+	// the view is written locked, so it's never written after Stop left the screen
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	if ui.stopped {
+		return
+	}
+	lines := ui.render(width, height)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	io.WriteString(ui.out, b.String())
+}
+
+// onEvent updates the state of the view with an event of the tests
+func (ui *UI) onEvent(_ string, e defs.Event) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	ui.state.update(e)
+}
+
+// logWriter keeps the lines logged for the log pane
+type logWriter struct {
+	ui *UI
+}
+
+// Write implements io.Writer
+func (w logWriter) Write(p []byte) (int, error) {
+	ui := w.ui
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	ui.logBuf.Write(p)
+	for {
+		line, err := ui.logBuf.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			ui.logBuf.WriteString(line)
+			break
+		}
+		ui.logs = append(ui.logs, line)
+	}
+	if len(ui.logs) > maxLogLines {
+		ui.logs = ui.logs[len(ui.logs)-maxLogLines:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,90 @@
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// prefixes are the multipliers of the unit prefixes accepted by ParseSize and ParseRate, case insensitive
+var prefixes = map[string]float64{
+	"":   1,
+	"k":  1e3,
+	"m":  1e6,
+	"g":  1e9,
+	"t":  1e12,
+	"ki": 1 << 10,
+	"mi": 1 << 20,
+	"gi": 1 << 30,
+	"ti": 1 << 40,
+}
+
+// ParseSize parses a size in bytes like "500MB", "1.5G" or "200MiB". A lowercase "b" is for bits, e.g. "80Mb" is 10MB.
+// Empty strings and "0" mean no limit
+func ParseSize(s string) (int64, error) {
+	bits, err := parseWithUnit(s, false)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %s", s, err)
+	}
+	return int64(bits / 8), nil
+}
+
+// ParseRate parses a rate in bits per second like "10M", "512kbps" or "1Gbps" and returns it in bytes per second. An
+// uppercase "B" is for bytes, e.g. "10MB/s" or "10MBps". Empty strings and "0" mean no limit
+func ParseRate(s string) (float64, error) {
+	bits, err := parseWithUnit(s, true)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %s", s, err)
+	}
+	return bits / 8, nil
+}
+
+// parseWithUnit parses a non-negative number followed by a unit made of a prefix, then "b" for bits or "B" for bytes,
+// then "ps" or "/s" for rates, and returns it in bits. Without "b" or "B", sizes are in bytes and rates in bits
+func parseWithUnit(s string, rate bool) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	idx := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if idx < 0 {
+		idx = len(s)
+	}
+
+	v, err := strconv.ParseFloat(s[:idx], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	unit := strings.TrimSpace(s[idx:])
+	prefix := unit
+	if rate {
+		if p, ok := strings.CutSuffix(prefix, "/s"); ok {
+			prefix = p
+		} else if p, ok := strings.CutSuffix(prefix, "ps"); ok {
+			prefix = p
+		}
+	}
+
+	bitsPerUnit := 8.0
+	if rate {
+		bitsPerUnit = 1
+	}
+	if p, ok := strings.CutSuffix(prefix, "b"); ok {
+		prefix, bitsPerUnit = p, 1
+	} else if p, ok := strings.CutSuffix(prefix, "B"); ok {
+		prefix, bitsPerUnit = p, 8
+	} else if prefix != unit {
+		// "ps" or "/s" without bits or bytes
+		return 0, fmt.Errorf("unknown unit %s", unit)
+	}
+
+	multiplier, ok := prefixes[strings.ToLower(prefix)]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %s", unit)
+	}
+	return v * multiplier * bitsPerUnit, nil
+}
@@ -0,0 +1,94 @@
+package units
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"", 0, true},
+		{"0", 0, true},
+		{"  ", 0, true},
+		{"1500", 1500, true},
+		{"100B", 100, true},
+		{"500KB", 500e3, true},
+		{"500kb", 62500, true},
+		{"50MB", 50e6, true},
+		{"50mB", 50e6, true},
+		{"50M", 50e6, true},
+		{"80Mb", 10e6, true},
+		{"1.5G", 1.5e9, true},
+		{"1.5GB", 1.5e9, true},
+		{"2TB", 2e12, true},
+		{"20MiB", 20 << 20, true},
+		{"20mib", 20 << 17, true},
+		{"20Mi", 20 << 20, true},
+		{"1GiB", 1 << 30, true},
+		{"0.5KiB", 512, true},
+		{"10 MB", 10e6, true},
+		{"MB", 0, false},
+		{"-5MB", 0, false},
+		{"1.2.3MB", 0, false},
+		{"10XB", 0, false},
+		{"10MB/s", 0, false},
+		{"10Mbps", 0, false},
+		{"ten", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseSize(%q) error %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"", 0, true},
+		{"0", 0, true},
+		{"8000", 1000, true},
+		{"8bps", 1, true},
+		{"10M", 1.25e6, true},
+		{"10m", 1.25e6, true},
+		{"10Mb", 1.25e6, true},
+		{"10Mbps", 1.25e6, true},
+		{"10mbps", 1.25e6, true},
+		{"10MB", 10e6, true},
+		{"10MBps", 10e6, true},
+		{"10MB/s", 10e6, true},
+		{"512kbps", 64e3, true},
+		{"1Gbps", 125e6, true},
+		{"2.5Gbps", 312.5e6, true},
+		{"1Mibps", 1 << 17, true},
+		{"1MiB/s", 1 << 20, true},
+		{"10 Mbps", 1.25e6, true},
+		{"Mbps", 0, false},
+		{"-1M", 0, false},
+		{"10Mps", 0, false},
+		{"10M/s", 0, false},
+		{"10Xbps", 0, false},
+		{"fast", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseRate(%q) error %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %g, want %g", tt.in, got, tt.want)
+		}
+	}
+}
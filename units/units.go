@@ -0,0 +1,61 @@
+// Package units formats and parses amounts of data and rates, in bits or bytes with SI or IEC prefixes
+package units
+
+import (
+	"fmt"
+	"math"
+)
+
+var (
+	// siPrefixes are the prefixes of powers of 1000
+	siPrefixes = []string{"", "K", "M", "G", "T"}
+	// iecPrefixes are the prefixes of powers of 1024
+	iecPrefixes = []string{"", "Ki", "Mi", "Gi", "Ti"}
+)
+
+// Units chooses how data is shown: rates in bits or bytes per second, scaled by powers of 1000 (SI) or 1024 (IEC)
+type Units struct {
+	Bytes bool
+	IEC   bool
+}
+
+// Rate returns a rate given in bits per second with the largest prefix keeping it above 1, e.g. "93.41 Mbps", or
+// "11.68 MB/s" in bytes
+func (u Units) Rate(bps float64) string {
+	if u.Bytes {
+		return u.scale(bps/8, "B/s")
+	}
+	return u.scale(bps, "bps")
+}
+
+// Mbps converts a rate given in bits per second to megabits per second, of 2^20 bits with IEC prefixes
+func (u Units) Mbps(bps float64) float64 {
+	if u.IEC {
+		return bps / (1 << 20)
+	}
+	return bps / 1e6
+}
+
+// Size returns an amount of bytes with the largest prefix keeping it above 1, e.g. "1.23 GB"
+func (u Units) Size(bytes int64) string {
+	return u.scale(float64(bytes), "B")
+}
+
+// scale returns the value with the prefix of its magnitude followed by the unit
+func (u Units) scale(v float64, unit string) string {
+	base, prefixes := 1000.0, siPrefixes
+	if u.IEC {
+		base, prefixes = 1024, iecPrefixes
+	}
+
+	i := 0
+	// compare the rounded value, so that 999.999 KB is shown as 1.00 MB
+	for i < len(prefixes)-1 && math.Abs(math.Round(v*100)/100) >= base {
+		v /= base
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", v, unit)
+	}
+	return fmt.Sprintf("%.2f %s%s", v, prefixes[i], unit)
+}